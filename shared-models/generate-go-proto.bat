@@ -7,6 +7,6 @@ REM Create output directory if it doesn't exist
 if not exist "%OUTPUT_DIR%" mkdir "%OUTPUT_DIR%"
 
 REM Generate Go code for each proto file
-protoc --go_out=%OUTPUT_DIR% --go_opt=paths=source_relative --proto_path=%PROTO_DIR% %PROTO_DIR%\*.proto
+protoc --go_out=%OUTPUT_DIR% --go_opt=paths=source_relative --go-grpc_out=%OUTPUT_DIR% --go-grpc_opt=paths=source_relative --proto_path=%PROTO_DIR% %PROTO_DIR%\*.proto
 
 echo Go proto files generated in %OUTPUT_DIR%
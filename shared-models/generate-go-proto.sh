@@ -9,6 +9,7 @@ mkdir -p "$OUTPUT_DIR"
 
 # Generate Go code for each proto file
 protoc --go_out="$OUTPUT_DIR" --go_opt=paths=source_relative \
+  --go-grpc_out="$OUTPUT_DIR" --go-grpc_opt=paths=source_relative \
   --proto_path="$PROTO_DIR" \
   "$PROTO_DIR"/*.proto
 
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// callerKey and claimsKey are the gin context keys of the authenticated user
+// and their token's claims.
+const (
+	callerKey = "auth.caller"
+	claimsKey = "auth.claims"
+)
+
+// meId stands for the caller wherever a user id goes, as in
+// POST /users/-/activities.
+const meId = "-"
+
+var (
+	errNoSubject   = errors.New("token has no subject")
+	errUnknownUser = errors.New("token subject is not a known user")
+)
+
+// authClaims are the claims read from bearer tokens. The subject is the id of
+// the calling user.
+type authClaims struct {
+	jwt.RegisteredClaims
+	// Scope is the space-separated OAuth 2.0 scope of the token.
+	Scope string `json:"scope,omitempty"`
+}
+
+// authenticator checks bearer tokens and resolves them to users.
+type authenticator struct {
+	users  UserStore
+	parser *jwt.Parser
+	// hmacKeys and rsaKeys are keyed by kid; keys configured without one
+	// are under "".
+	hmacKeys map[string][]byte
+	rsaKeys  map[string]*rsa.PublicKey
+}
+
+// newAuthenticator loads the configured keys. It returns nil when none are
+// configured, which leaves the service open.
+func newAuthenticator(cfg authConfig, users UserStore) (*authenticator, error) {
+	if !cfg.enabled() {
+		return nil, nil
+	}
+	a := &authenticator{
+		users:    users,
+		hmacKeys: make(map[string][]byte),
+		rsaKeys:  make(map[string]*rsa.PublicKey),
+	}
+	if cfg.HS256Secret != "" {
+		a.hmacKeys[""] = []byte(cfg.HS256Secret)
+	}
+	if cfg.RS256PublicKey != "" {
+		key, err := loadRSAPublicKey(cfg.RS256PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("auth.rs256-public-key: %w", err)
+		}
+		a.rsaKeys[""] = key
+	}
+	if cfg.JWKSFile != "" {
+		if err := a.loadJWKS(cfg.JWKSFile); err != nil {
+			return nil, fmt.Errorf("auth.jwks-file: %w", err)
+		}
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	a.parser = jwt.NewParser(opts...)
+	return a, nil
+}
+
+// loadRSAPublicKey reads a PEM public key or certificate.
+func loadRSAPublicKey(file string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var key any
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an RSA key", key)
+	}
+	return rsaKey, nil
+}
+
+// jsonWebKey is the part of an RFC 7517 key that RSA and symmetric keys use.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// loadJWKS adds the signing keys of a JWKS file. Key types other than RSA
+// and oct are skipped.
+func (a *authenticator) loadJWKS(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return err
+	}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+			if err != nil {
+				return fmt.Errorf("key %q: n: %w", jwk.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+			if err != nil {
+				return fmt.Errorf("key %q: e: %w", jwk.Kid, err)
+			}
+			exponent := new(big.Int).SetBytes(e)
+			if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+				return fmt.Errorf("key %q: bad exponent", jwk.Kid)
+			}
+			a.rsaKeys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+		case "oct":
+			k, err := base64.RawURLEncoding.DecodeString(jwk.K)
+			if err != nil {
+				return fmt.Errorf("key %q: k: %w", jwk.Kid, err)
+			}
+			a.hmacKeys[jwk.Kid] = k
+		}
+	}
+	return nil
+}
+
+// key picks the key a token is verified with by its kid, falling back to the
+// key configured without one.
+func (a *authenticator) key(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		if key, ok := a.hmacKeys[kid]; ok {
+			return key, nil
+		}
+		if key, ok := a.hmacKeys[""]; ok {
+			return key, nil
+		}
+	case jwt.SigningMethodRS256.Alg():
+		if key, ok := a.rsaKeys[kid]; ok {
+			return key, nil
+		}
+		if key, ok := a.rsaKeys[""]; ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s key %q", token.Method.Alg(), kid)
+}
+
+// Authenticate verifies a token and loads the user it was issued to. A token
+// whose subject isn't a user is a service account's, which is accepted only
+// with one of the service scopes, and returns no user.
+func (a *authenticator) Authenticate(ctx context.Context, token string) (*proto.User, *authClaims, error) {
+	var claims authClaims
+	if _, err := a.parser.ParseWithClaims(token, &claims, a.key); err != nil {
+		return nil, nil, err
+	}
+	if claims.Subject == "" {
+		return nil, nil, errNoSubject
+	}
+	user, err := a.users.GetUser(ctx, claims.Subject)
+	if errors.Is(err, ErrNotFound) {
+		if slices.ContainsFunc(serviceScopes, claims.hasScope) {
+			return nil, &claims, nil
+		}
+		return nil, nil, errUnknownUser
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, &claims, nil
+}
+
+// authenticate returns middleware that authenticates the bearer token of the
+// request, if there is one, and puts the user and claims in the context.
+// With required set, requests without a token are turned away. With
+// fromQuery set, the token may also come as ?access_token=, for
+// EventSource and browser WebSockets, which can't set headers. An id
+// parameter of "-" is replaced with the caller's id.
+//
+// When authentication is off every request passes as anonymous, except that
+// required routes are turned away unless the server is insecure.
+func (s *server) authenticate(required, fromQuery bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.auth != nil {
+			token := bearerToken(c, fromQuery)
+			switch {
+			case token != "":
+				user, claims, err := s.auth.Authenticate(c.Request.Context(), token)
+				if err != nil && isTokenError(err) {
+					unauthorized(c, "invalid_token", err.Error())
+					return
+				}
+				if err != nil {
+					internalError(c, err)
+					c.Abort()
+					return
+				}
+				if user != nil {
+					c.Set(callerKey, user)
+				}
+				c.Set(claimsKey, claims)
+			case required:
+				unauthorized(c, "", "authentication is required")
+				return
+			}
+		} else if required && !s.insecure {
+			unauthorized(c, "", "authentication is not configured")
+			return
+		}
+
+		for i, param := range c.Params {
+			if param.Key != "id" || param.Value != meId {
+				continue
+			}
+			caller := callerFromContext(c)
+			if caller == nil {
+				unauthorized(c, "", "authentication is required for "+meId)
+				return
+			}
+			c.Params[i].Value = caller.Id
+		}
+		c.Next()
+	}
+}
+
+// bearerToken reads the token from the Authorization header, or from
+// ?access_token= if fromQuery is set.
+func bearerToken(c *gin.Context, fromQuery bool) string {
+	if token := parseBearer(c.GetHeader("Authorization")); token != "" {
+		return token
+	}
+	if fromQuery {
+		return c.Query("access_token")
+	}
+	return ""
+}
+
+// parseBearer returns the token of a "Bearer <token>" authorization, or ""
+// for any other scheme.
+func parseBearer(authorization string) string {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// isTokenError reports whether err is the token's fault rather than ours.
+func isTokenError(err error) bool {
+	for _, tokenErr := range []error{
+		jwt.ErrTokenMalformed, jwt.ErrTokenUnverifiable, jwt.ErrTokenSignatureInvalid,
+		jwt.ErrTokenExpired, jwt.ErrTokenNotValidYet, jwt.ErrTokenUsedBeforeIssued,
+		jwt.ErrTokenInvalidIssuer, jwt.ErrTokenInvalidAudience, jwt.ErrTokenRequiredClaimMissing,
+		jwt.ErrTokenInvalidClaims, jwt.ErrTokenInvalidSubject, errNoSubject, errUnknownUser,
+	} {
+		if errors.Is(err, tokenErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// unauthorized aborts with a 401 and the RFC 6750 challenge. code is the
+// OAuth error code, empty when no token was given.
+func unauthorized(c *gin.Context, code, description string) {
+	challenge := `Bearer realm="user-service"`
+	if code != "" {
+		challenge += fmt.Sprintf(`, error=%q, error_description=%q`, code, description)
+	}
+	c.Header("WWW-Authenticate", challenge)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": description})
+}
+
+// grpcAuthKey is the context key of the caller and claims of a gRPC call.
+type grpcAuthKey struct{}
+
+type grpcAuth struct {
+	caller *proto.User
+	claims *authClaims
+}
+
+// grpcAuthFromContext returns what grpcAuthenticate found, which is nothing
+// for anonymous calls.
+func grpcAuthFromContext(ctx context.Context) (*proto.User, *authClaims) {
+	auth, _ := ctx.Value(grpcAuthKey{}).(grpcAuth)
+	return auth.caller, auth.claims
+}
+
+// claimsFromContext returns the claims of the caller's token, or nil for
+// anonymous requests.
+func claimsFromContext(c *gin.Context) *authClaims {
+	claims, _ := c.Get(claimsKey)
+	authClaims, _ := claims.(*authClaims)
+	return authClaims
+}
+
+// callerFromContext returns the authenticated user, or nil for anonymous
+// requests and service accounts.
+func callerFromContext(c *gin.Context) *proto.User {
+	caller, _ := c.Get(callerKey)
+	user, _ := caller.(*proto.User)
+	return user
+}
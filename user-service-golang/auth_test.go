@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func generateRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// sign signs a token for user 1, valid for an hour, with claims added over
+// that; a nil claim removes it.
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	all := jwt.MapClaims{"sub": "1", "exp": time.Now().Add(time.Hour).Unix()}
+	for name, value := range claims {
+		if value == nil {
+			delete(all, name)
+		} else {
+			all[name] = value
+		}
+	}
+	token := jwt.NewWithClaims(method, all)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// authenticateWith runs Authenticate on a new authenticator of cfg over the seed
+// users.
+func authenticateWith(t *testing.T, cfg authConfig, token string) (string, *authClaims, error) {
+	t.Helper()
+	a, err := newAuthenticator(cfg, newSeededMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, claims, err := a.Authenticate(context.Background(), token)
+	if user != nil {
+		return user.Id, claims, err
+	}
+	return "", claims, err
+}
+
+func TestAuthenticateRS256PEM(t *testing.T) {
+	key, other := generateRSAKey(t), generateRSAKey(t)
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, block := range map[string]*pem.Block{
+		"PKIX":  {Type: "PUBLIC KEY", Bytes: pkix},
+		"PKCS1": {Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := authConfig{RS256PublicKey: writeFile(t, "key.pem", string(pem.EncodeToMemory(block)))}
+			if id, _, err := authenticateWith(t, cfg, sign(t, jwt.SigningMethodRS256, key, "", nil)); err != nil || id != "1" {
+				t.Fatalf("user %q, err %v", id, err)
+			}
+			if _, _, err := authenticateWith(t, cfg, sign(t, jwt.SigningMethodRS256, other, "", nil)); !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+				t.Fatalf("err = %v for another key", err)
+			}
+			// An HS256 token can't pass itself off as signed with the
+			// public key.
+			if _, _, err := authenticateWith(t, cfg, sign(t, jwt.SigningMethodHS256, pkix, "", nil)); err == nil {
+				t.Fatal("HS256 token signed with the public key accepted")
+			}
+		})
+	}
+}
+
+func TestAuthenticateJWKS(t *testing.T) {
+	a, b := generateRSAKey(t), generateRSAKey(t)
+	rsaJWK := func(kid string, key *rsa.PrivateKey) map[string]string {
+		return map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	}
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		rsaJWK("a", a),
+		rsaJWK("b", b),
+		{"kty": "oct", "kid": "c", "k": base64.RawURLEncoding.EncodeToString(secret)},
+		{"kty": "EC", "kid": "d", "crv": "P-256"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := authConfig{JWKSFile: writeFile(t, "jwks.json", string(jwks))}
+
+	for name, test := range map[string]struct {
+		token string
+		ok    bool
+	}{
+		"first key":       {sign(t, jwt.SigningMethodRS256, a, "a", nil), true},
+		"second key":      {sign(t, jwt.SigningMethodRS256, b, "b", nil), true},
+		"symmetric key":   {sign(t, jwt.SigningMethodHS256, secret, "c", nil), true},
+		"wrong kid":       {sign(t, jwt.SigningMethodRS256, b, "a", nil), false},
+		"unknown kid":     {sign(t, jwt.SigningMethodRS256, a, "z", nil), false},
+		"no kid":          {sign(t, jwt.SigningMethodRS256, a, "", nil), false},
+		"kid of RSA key":  {sign(t, jwt.SigningMethodHS256, secret, "a", nil), false},
+		"unsupported alg": {sign(t, jwt.SigningMethodRS512, a, "a", nil), false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			id, _, err := authenticateWith(t, cfg, test.token)
+			if test.ok && (err != nil || id != "1") {
+				t.Fatalf("user %q, err %v", id, err)
+			}
+			if !test.ok && err == nil {
+				t.Fatal("token accepted")
+			}
+		})
+	}
+}
+
+func TestAuthenticateClaims(t *testing.T) {
+	now := time.Now()
+	cfg := authConfig{HS256Secret: testSecret, Issuer: "https://auth.example.com", Audience: "user-service", Leeway: time.Minute}
+	valid := jwt.MapClaims{"iss": "https://auth.example.com", "aud": []string{"feed", "user-service"}}
+	with := func(changes jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		for name, value := range valid {
+			claims[name] = value
+		}
+		for name, value := range changes {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	for name, test := range map[string]struct {
+		claims jwt.MapClaims
+		err    error
+	}{
+		"valid":                    {valid, nil},
+		"expired within leeway":    {with(jwt.MapClaims{"exp": now.Add(-30 * time.Second).Unix()}), nil},
+		"expired":                  {with(jwt.MapClaims{"exp": now.Add(-2 * time.Minute).Unix()}), jwt.ErrTokenExpired},
+		"no expiry":                {with(jwt.MapClaims{"exp": nil}), jwt.ErrTokenRequiredClaimMissing},
+		"not yet valid":            {with(jwt.MapClaims{"nbf": now.Add(2 * time.Minute).Unix()}), jwt.ErrTokenNotValidYet},
+		"not yet valid, in leeway": {with(jwt.MapClaims{"nbf": now.Add(30 * time.Second).Unix()}), nil},
+		"other issuer":             {with(jwt.MapClaims{"iss": "https://evil.example.com"}), jwt.ErrTokenInvalidIssuer},
+		"no issuer":                {with(jwt.MapClaims{"iss": nil}), jwt.ErrTokenRequiredClaimMissing},
+		"other audience":           {with(jwt.MapClaims{"aud": "billing"}), jwt.ErrTokenInvalidAudience},
+		"no audience":              {with(jwt.MapClaims{"aud": nil}), jwt.ErrTokenRequiredClaimMissing},
+		"no subject":               {with(jwt.MapClaims{"sub": nil}), errNoSubject},
+	} {
+		t.Run(name, func(t *testing.T) {
+			id, _, err := authenticateWith(t, cfg, sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", test.claims))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if test.err == nil && id != "1" {
+				t.Fatalf("user %q", id)
+			}
+		})
+	}
+}
+
+func TestAuthenticateServiceAccounts(t *testing.T) {
+	cfg := authConfig{HS256Secret: testSecret}
+	for name, test := range map[string]struct {
+		scope string
+		err   error
+	}{
+		"no scope":      {"", errUnknownUser},
+		"user scope":    {"profile email", errUnknownUser},
+		"service scope": {"profile " + scopeActivitiesWriteAny, nil},
+		"admin":         {scopeAdmin, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			claims := jwt.MapClaims{"sub": "processor"}
+			if test.scope != "" {
+				claims["scope"] = test.scope
+			}
+			id, got, err := authenticateWith(t, cfg, sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if id != "" {
+				t.Fatalf("service account resolved to user %q", id)
+			}
+			if test.err == nil && (got == nil || got.Subject != "processor") {
+				t.Fatalf("claims = %+v", got)
+			}
+		})
+	}
+
+	// The HTTP API tells an unknown subject from a bad token.
+	ts := newTestServer(t)
+	w := ts.do(t, http.MethodGet, "/users/1", token(t, "ghost", ""), nil)
+	expectStatus(t, w, http.StatusUnauthorized)
+	if challenge := w.Header().Get("WWW-Authenticate"); challenge == "" {
+		t.Fatal("no WWW-Authenticate challenge")
+	}
+}
+
+func TestAccessTokenOnlyOnStreamRoutes(t *testing.T) {
+	ts := newTestServer(t)
+	alice := token(t, "1", "")
+
+	// "-" needs a caller, so only a token that is read lets these through.
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/-", alice, nil), http.StatusOK)
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/-?access_token="+alice, "", nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/-/feed?access_token="+alice, "", nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(t, http.MethodPatch, "/users/-?access_token="+alice, "", map[string]any{"name": "Mallory"}), http.StatusUnauthorized)
+
+	openStream(t, ts, "/users/-/activities/stream?access_token="+alice)
+}
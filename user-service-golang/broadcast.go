@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// activityEvent is one created or updated activity as broadcast. Seq orders
+// events within this process.
+type activityEvent struct {
+	Seq      uint64
+	Activity *proto.UserActivity
+}
+
+// activityBroadcaster fans stored activities out to in-process subscribers
+// and keeps a short history so that reconnecting clients can catch up.
+type activityBroadcaster struct {
+	// epoch tells event ids of this process from those of earlier ones.
+	epoch       int64
+	historySize int
+	bufferSize  int
+
+	mu          sync.Mutex
+	seq         uint64
+	history     []activityEvent
+	subscribers map[*activitySubscription]struct{}
+}
+
+// activitySubscription receives matching events on C. C is closed when the
+// subscriber falls too far behind or is closed, after which it has to
+// resubscribe from its last event id.
+type activitySubscription struct {
+	C      <-chan activityEvent
+	c      chan activityEvent
+	filter func(*proto.UserActivity) bool
+	b      *activityBroadcaster
+}
+
+func newActivityBroadcaster(historySize, bufferSize int) *activityBroadcaster {
+	return &activityBroadcaster{
+		epoch:       time.Now().UnixNano(),
+		historySize: historySize,
+		bufferSize:  bufferSize,
+		subscribers: make(map[*activitySubscription]struct{}),
+	}
+}
+
+// Publish broadcasts an activity that has just been stored. An activity
+// identical to the last one broadcast for its feed is ignored, so a write
+// that arrives both locally and through a change notification goes out once.
+func (b *activityBroadcaster) Publish(activity *proto.UserActivity) {
+	activity = cloneActivity(activity)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := len(b.history) - 1; i >= 0; i-- {
+		if b.history[i].Activity.FeedId == activity.FeedId {
+			if protobuf.Equal(b.history[i].Activity, activity) {
+				return
+			}
+			break
+		}
+	}
+
+	b.seq++
+	event := activityEvent{Seq: b.seq, Activity: activity}
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for sub := range b.subscribers {
+		if !sub.filter(activity) {
+			continue
+		}
+		select {
+		case sub.c <- event:
+		default:
+			// Too slow; drop it rather than hold everyone else up.
+			delete(b.subscribers, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Subscribe starts delivering events matching filter. With a lastEventId it
+// first replays the matching events after it; resumed is false when that id
+// is unknown or has aged out of the history, so the client may have missed
+// events and should reload.
+func (b *activityBroadcaster) Subscribe(lastEventId string, filter func(*proto.UserActivity) bool) (sub *activitySubscription, replay []activityEvent, resumed bool) {
+	c := make(chan activityEvent, b.bufferSize)
+	sub = &activitySubscription{C: c, c: c, filter: filter, b: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	resumed = true
+	if lastEventId != "" {
+		seq, ok := b.parseEventId(lastEventId)
+		// Every event after seq must still be in the history.
+		resumed = ok && (len(b.history) == 0 || seq+1 >= b.history[0].Seq)
+		if resumed {
+			for _, event := range b.history {
+				if event.Seq > seq && filter(event.Activity) {
+					replay = append(replay, event)
+				}
+			}
+		}
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub, replay, resumed
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (sub *activitySubscription) Close() {
+	b := sub.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.c)
+	}
+}
+
+// EventId is the SSE id of an event: "<epoch>-<seq>".
+func (b *activityBroadcaster) EventId(event activityEvent) string {
+	return fmt.Sprintf("%d-%d", b.epoch, event.Seq)
+}
+
+func (b *activityBroadcaster) parseEventId(id string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != strconv.FormatInt(b.epoch, 10) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil || n > b.seq {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/lib/pq"
+)
+
+// activityChangesChannel is notified of every changed activity by the
+// triggers in db/init.sql, whoever made the change. The payload is an
+// activityChange.
+const activityChangesChannel = "user_activity_changes"
+
+// activityChange is the payload of a notification on activityChangesChannel.
+type activityChange struct {
+	FeedId    string    `json:"feed_id"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// parseActivityChange reads a notification payload. Triggers from before
+// migration 0008 send the bare feed_id, which comes back with no time.
+func parseActivityChange(payload string) activityChange {
+	var change activityChange
+	if err := json.Unmarshal([]byte(payload), &change); err != nil || change.FeedId == "" {
+		return activityChange{FeedId: payload}
+	}
+	return change
+}
+
+// ActivityChangeStore is what the change listener reads: the activities and
+// the log of when each last changed, kept by the database.
+type ActivityChangeStore interface {
+	GetActivity(ctx context.Context, feedId string) (*proto.UserActivity, error)
+	// Now is the time on the clock changes are logged by.
+	Now(ctx context.Context) (time.Time, error)
+	// ChangedActivities lists the feed ids of activities changed at or
+	// after since, oldest change first.
+	ChangedActivities(ctx context.Context, since time.Time) ([]string, error)
+}
+
+// ActivityNotifier delivers the notifications of a channel, and a nil one
+// after reconnecting. *pq.Listener is one.
+type ActivityNotifier interface {
+	Listen(channel string) error
+	NotificationChannel() <-chan *pq.Notification
+	Ping() error
+	Close() error
+}
+
+// activityChangeListener listens for activity changes made through any
+// instance, or the Java processor, and hands the changed activities to
+// publish. After a dropped connection it catches up from
+// user_activity_changes, from the last change it handled, looking back an
+// extra overlap to cover transactions that were still open at the time.
+type activityChangeListener struct {
+	store    ActivityChangeStore
+	notifier ActivityNotifier
+	publish  func(*proto.UserActivity)
+	overlap  time.Duration
+}
+
+func newActivityChangeListener(connStr string, store ActivityChangeStore, publish func(*proto.UserActivity), overlap time.Duration) *activityChangeListener {
+	listener := pq.NewListener(connStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			slog.Warn("Lost activity change listener connection", "error", err)
+		case pq.ListenerEventConnectionAttemptFailed:
+			slog.Warn("Failed to reconnect activity change listener", "error", err)
+		case pq.ListenerEventReconnected:
+			slog.Info("Reconnected activity change listener")
+		}
+	})
+	return &activityChangeListener{store: store, notifier: listener, publish: publish, overlap: overlap}
+}
+
+// Run listens until ctx is done.
+func (l *activityChangeListener) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { l.notifier.Close() })
+	defer stop()
+
+	if err := l.notifier.Listen(activityChangesChannel); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	// Changes from before we listened aren't ours to catch up on.
+	since, err := l.store.Now(ctx)
+	if err != nil {
+		return err
+	}
+	// failed is set once a change couldn't be handled, from when since
+	// stays put so the next catch up retries it.
+	failed := false
+
+	// pq only notices a dead connection when it next uses it.
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+	notifications := l.notifier.NotificationChannel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n, ok := <-notifications:
+			if !ok {
+				return nil
+			}
+			if n == nil {
+				// Reconnected; notifications may have been lost meanwhile.
+				if caughtUp, err := l.catchUp(ctx, since); err != nil {
+					slog.ErrorContext(ctx, "Failed to catch up on activity changes", "error", err)
+				} else {
+					since, failed = caughtUp, false
+				}
+				continue
+			}
+			change := parseActivityChange(n.Extra)
+			if err := l.changed(ctx, change.FeedId); err != nil {
+				slog.ErrorContext(ctx, "Failed to load changed activity", "feed_id", change.FeedId, "error", err)
+				failed = true
+				continue
+			}
+			if !failed && change.ChangedAt.After(since) {
+				since = change.ChangedAt
+			}
+		case <-ping.C:
+			if err := l.notifier.Ping(); err != nil {
+				slog.WarnContext(ctx, "Activity change listener ping failed", "error", err)
+			}
+		}
+	}
+}
+
+// catchUp publishes the activities changed since a database time, and
+// returns the time to catch up from next.
+func (l *activityChangeListener) catchUp(ctx context.Context, since time.Time) (time.Time, error) {
+	now, err := l.store.Now(ctx)
+	if err != nil {
+		return since, err
+	}
+	feedIds, err := l.store.ChangedActivities(ctx, since.Add(-l.overlap))
+	if err != nil {
+		return since, err
+	}
+	for _, feedId := range feedIds {
+		if err := l.changed(ctx, feedId); err != nil {
+			return since, err
+		}
+	}
+	return now, nil
+}
+
+// changed publishes the current state of an activity. Deleted activities
+// are not broadcast.
+func (l *activityChangeListener) changed(ctx context.Context, feedId string) error {
+	activity, err := l.store.GetActivity(ctx, feedId)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	l.publish(activity)
+	return nil
+}
+
+func (s *postgresStore) Now(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	err := s.db.QueryRowContext(ctx, "SELECT now()").Scan(&now)
+	return now, err
+}
+
+func (s *postgresStore) ChangedActivities(ctx context.Context, since time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT feed_id FROM user_activity_changes WHERE changed_at >= $1 ORDER BY changed_at", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feedIds []string
+	for rows.Next() {
+		var feedId string
+		if err := rows.Scan(&feedId); err != nil {
+			return nil, err
+		}
+		feedIds = append(feedIds, feedId)
+	}
+	return feedIds, rows.Err()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/lib/pq"
+)
+
+// changeLog is an ActivityChangeStore over a memory store with a change log
+// and clock the test sets.
+type changeLog struct {
+	*memoryStore
+
+	mu      sync.Mutex
+	now     time.Time
+	changes map[string]time.Time
+	failing map[string]bool
+	// queries receives the since of every ChangedActivities.
+	queries chan time.Time
+}
+
+func newChangeLog(now time.Time) *changeLog {
+	return &changeLog{
+		memoryStore: newMemoryStore(),
+		now:         now,
+		changes:     make(map[string]time.Time),
+		failing:     make(map[string]bool),
+		queries:     make(chan time.Time, 10),
+	}
+}
+
+// change stores an activity as changed at, without telling anyone.
+func (s *changeLog) change(t *testing.T, feedId string, at time.Time) {
+	t.Helper()
+	err := s.UpsertActivity(context.Background(), &proto.UserActivity{
+		FeedId:             feedId,
+		ActionTextTemplate: "{subject} waved.",
+		SubjectReferring:   []*proto.UserActivityReferring{{Type: proto.ReferringType_USER, Id: "1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changes[feedId] = at
+}
+
+func (s *changeLog) setNow(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+func (s *changeLog) setFailing(feedId string, failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing[feedId] = failing
+}
+
+func (s *changeLog) GetActivity(ctx context.Context, feedId string) (*proto.UserActivity, error) {
+	s.mu.Lock()
+	failing := s.failing[feedId]
+	s.mu.Unlock()
+	if failing {
+		return nil, errors.New("connection refused")
+	}
+	return s.memoryStore.GetActivity(ctx, feedId)
+}
+
+func (s *changeLog) Now(ctx context.Context) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now, nil
+}
+
+func (s *changeLog) ChangedActivities(ctx context.Context, since time.Time) ([]string, error) {
+	s.queries <- since
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var feedIds []string
+	for feedId, at := range s.changes {
+		if !at.Before(since) {
+			feedIds = append(feedIds, feedId)
+		}
+	}
+	slices.SortFunc(feedIds, func(a, b string) int { return s.changes[a].Compare(s.changes[b]) })
+	return feedIds, nil
+}
+
+// testNotifier is an ActivityNotifier the test sends notifications through.
+type testNotifier struct {
+	notify chan *pq.Notification
+}
+
+func (n *testNotifier) Listen(channel string) error { return nil }
+
+func (n *testNotifier) NotificationChannel() <-chan *pq.Notification { return n.notify }
+
+func (n *testNotifier) Ping() error { return nil }
+
+func (n *testNotifier) Close() error { return nil }
+
+// notifyChange sends the notification the trigger would for a change.
+func (n *testNotifier) notifyChange(feedId string, at time.Time) {
+	n.notify <- &pq.Notification{
+		Channel: activityChangesChannel,
+		Extra:   `{"feed_id" : "` + feedId + `", "changed_at" : "` + at.Format("2006-01-02T15:04:05.999999-07:00") + `"}`,
+	}
+}
+
+// reconnect tells the listener the connection was lost and is back.
+func (n *testNotifier) reconnect() {
+	n.notify <- nil
+}
+
+// startChangeListener runs a listener over store and returns the notifier
+// feeding it and the feed ids it publishes.
+func startChangeListener(t *testing.T, store *changeLog, overlap time.Duration) (*testNotifier, <-chan string) {
+	t.Helper()
+	notifier := &testNotifier{notify: make(chan *pq.Notification)}
+	published := make(chan string, 10)
+	l := &activityChangeListener{
+		store:    store,
+		notifier: notifier,
+		publish:  func(activity *proto.UserActivity) { published <- activity.FeedId },
+		overlap:  overlap,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return notifier, published
+}
+
+// expectPublished waits for the listener to publish want, in any order.
+func expectPublished(t *testing.T, published <-chan string, want ...string) {
+	t.Helper()
+	var got []string
+	for range want {
+		select {
+		case feedId := <-published:
+			got = append(got, feedId)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("published %v, want %v", got, want)
+		}
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+}
+
+// expectCatchUp waits for the listener to catch up and checks from when.
+func expectCatchUp(t *testing.T, store *changeLog, since time.Time) {
+	t.Helper()
+	select {
+	case got := <-store.queries:
+		if !got.Equal(since) {
+			t.Fatalf("caught up from %v, want %v", got, since)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener did not catch up")
+	}
+}
+
+func TestParseActivityChange(t *testing.T) {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 123456000, time.FixedZone("", 2*60*60))
+	// As json_build_object renders a timestamptz.
+	change := parseActivityChange(`{"feed_id" : "feed1", "changed_at" : "2024-06-01T12:00:00.123456+02:00"}`)
+	if change.FeedId != "feed1" || !change.ChangedAt.Equal(at) {
+		t.Fatalf("change = %+v", change)
+	}
+	// Triggers from before the payload had a time.
+	if change := parseActivityChange("feed1"); change.FeedId != "feed1" || !change.ChangedAt.IsZero() {
+		t.Fatalf("bare change = %+v", change)
+	}
+}
+
+func TestActivityChangeListenerCatchesUpAfterReconnect(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	overlap := time.Minute
+	store := newChangeLog(start)
+	notifier, published := startChangeListener(t, store, overlap)
+
+	store.change(t, "feed1", start.Add(time.Second))
+	notifier.notifyChange("feed1", start.Add(time.Second))
+	expectPublished(t, published, "feed1")
+
+	// While the connection is down, feed2 changes unnoticed, and feed3
+	// commits a transaction begun just before feed1's. A change from before
+	// the overlap has been published already.
+	store.change(t, "feed0", start.Add(-2*overlap))
+	store.change(t, "feed2", start.Add(10*time.Second))
+	store.change(t, "feed3", start.Add(time.Second-overlap/2))
+	store.setNow(start.Add(time.Hour))
+	notifier.reconnect()
+	expectCatchUp(t, store, start.Add(time.Second-overlap))
+	expectPublished(t, published, "feed1", "feed2", "feed3")
+
+	// The next catch up starts from when the last one did.
+	notifier.reconnect()
+	expectCatchUp(t, store, start.Add(time.Hour-overlap))
+}
+
+func TestActivityChangeListenerRetriesFailedChanges(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := newChangeLog(start)
+	notifier, published := startChangeListener(t, store, 0)
+
+	store.change(t, "feed1", start.Add(time.Second))
+	store.change(t, "feed2", start.Add(2*time.Second))
+	store.setFailing("feed1", true)
+	notifier.notifyChange("feed1", start.Add(time.Second))
+	notifier.notifyChange("feed2", start.Add(2*time.Second))
+	expectPublished(t, published, "feed2")
+
+	// feed2 doesn't move the catch up past feed1, which failed.
+	store.setFailing("feed1", false)
+	notifier.reconnect()
+	expectCatchUp(t, store, start)
+	expectPublished(t, published, "feed1", "feed2")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/gin-gonic/gin"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// collapseActivities merges similar activities the way the stream processor
+// does, for when it isn't running: activities with the same template and the
+// same objects, created in the same window-long time slot, become one with
+// all of their subjects. Slots are fixed, like the processor's tumbling
+// windows, so how an activity collapses doesn't depend on what else is
+// listed. The result is newest first. Merged activities take the feed id and
+// creation time of their newest constituent and list the feed ids of all of
+// them in FeedIds; the rest are returned as they are.
+func collapseActivities(activities []*proto.UserActivity, window time.Duration) []*proto.UserActivity {
+	sorted := slices.Clone(activities)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareActivityKeys(activityKey(sorted[i]), activityKey(sorted[j])) < 0
+	})
+
+	type group struct {
+		key  string
+		slot time.Time
+	}
+	var groups [][]*proto.UserActivity
+	index := make(map[group]int)
+	for _, activity := range sorted {
+		g := group{key: collapseKey(activity), slot: collapseSlot(activity, window)}
+		if i, ok := index[g]; ok {
+			groups[i] = append(groups[i], activity)
+			continue
+		}
+		index[g] = len(groups)
+		groups = append(groups, []*proto.UserActivity{activity})
+	}
+
+	collapsed := make([]*proto.UserActivity, len(groups))
+	for i, group := range groups {
+		collapsed[i] = mergeActivities(group)
+	}
+	return collapsed
+}
+
+// collapseSlot is the start of the time slot the activity collapses within.
+func collapseSlot(activity *proto.UserActivity, window time.Duration) time.Time {
+	return activityKey(activity).CreatedAt.Truncate(window)
+}
+
+// collapsedPageBatch is how many activities listCollapsedPage reads at a time.
+const collapsedPageBatch = 100
+
+// listCollapsedPage pages over collapsed activities, whose keys are those of
+// their newest constituents. It reads from the store in batches until it has
+// the page and can tell whether there is more. Activities only collapse
+// within their slot, so the slot of the cursor is all that has to be read
+// again to tell which of its activities went into groups on earlier pages.
+func listCollapsedPage(ctx context.Context, store ActivityStore, f activityFilter, q pageQuery, window time.Duration) ([]*proto.UserActivity, bool, error) {
+	backward := q.Before != nil
+	cursor := q.After
+	read := pageQuery{Limit: max(q.Limit+1, collapsedPageBatch)}
+	if backward {
+		cursor = q.Before
+		read.Before = &pageKey{CreatedAt: cursor.CreatedAt.Truncate(window)}
+	} else if cursor != nil {
+		read.After = &pageKey{CreatedAt: cursor.CreatedAt.Truncate(window).Add(window)}
+	}
+
+	var activities []*proto.UserActivity
+	for {
+		batch, more, err := store.ListActivitiesPage(ctx, f, read)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if backward {
+			activities = append(batch, activities...)
+			first := activityKey(batch[0])
+			read.Before = &first
+		} else {
+			activities = append(activities, batch...)
+			last := activityKey(batch[len(batch)-1])
+			read.After = &last
+		}
+		if !more {
+			break
+		}
+		// The slot read last may go on past what has been read.
+		if groups := collapsedPageGroups(activities, window, cursor, backward, true); len(groups) > q.Limit {
+			break
+		}
+	}
+
+	groups := collapsedPageGroups(activities, window, cursor, backward, false)
+	if len(groups) <= q.Limit {
+		return groups, false, nil
+	}
+	if backward {
+		return groups[len(groups)-q.Limit:], true, nil
+	}
+	return groups[:q.Limit], true, nil
+}
+
+// collapsedPageGroups collapses activities read from the store in the
+// direction of a page and keeps the groups past cursor, and with
+// completeOnly only those outside the slot read last.
+func collapsedPageGroups(activities []*proto.UserActivity, window time.Duration, cursor *pageKey, backward, completeOnly bool) []*proto.UserActivity {
+	if len(activities) == 0 {
+		return nil
+	}
+	var lastSlot time.Time
+	if backward {
+		lastSlot = collapseSlot(activities[0], window)
+	} else {
+		lastSlot = collapseSlot(activities[len(activities)-1], window)
+	}
+	return slices.DeleteFunc(collapseActivities(activities, window), func(group *proto.UserActivity) bool {
+		if completeOnly && collapseSlot(group, window).Equal(lastSlot) {
+			return true
+		}
+		if cursor == nil {
+			return false
+		}
+		c := compareActivityKeys(activityKey(group), *cursor)
+		return backward && c >= 0 || !backward && c <= 0
+	})
+}
+
+// listActivitiesPage reads a page of activities, collapsed if collapse is
+// set.
+func (s *server) listActivitiesPage(ctx context.Context, f activityFilter, q pageQuery, collapse bool) ([]*proto.UserActivity, bool, error) {
+	if collapse {
+		return listCollapsedPage(ctx, s.activities, f, q, s.collapseWindow)
+	}
+	return s.activities.ListActivitiesPage(ctx, f, q)
+}
+
+// collapseKey is what activities that can be merged have in common: their
+// template and their set of objects, in whatever order. Unlike similarityKey
+// it leaves out the subjects, which are what merging gathers up.
+func collapseKey(activity *proto.UserActivity) string {
+	objects := make([]string, len(activity.ObjectReferring))
+	for i, referring := range activity.ObjectReferring {
+		objects[i] = referring.Type.String() + "#" + referring.Id
+	}
+	slices.Sort(objects)
+	objects = slices.Compact(objects)
+	return activity.ActionTextTemplate + "\x00" + strings.Join(objects, "\x00")
+}
+
+// mergeActivities merges a group of similar activities, newest first. The
+// subjects are kept oldest first, each where it last acted, so the newest is
+// last and headlines the text as it does for the stream processor.
+func mergeActivities(group []*proto.UserActivity) *proto.UserActivity {
+	if len(group) == 1 {
+		return group[0]
+	}
+	merged := protobuf.Clone(group[0]).(*proto.UserActivity)
+	merged.SubjectReferring = nil
+	seen := make(map[string]bool)
+	for _, activity := range group {
+		merged.FeedIds = append(merged.FeedIds, activity.FeedId)
+		for _, referring := range slices.Backward(activity.SubjectReferring) {
+			key := referring.Type.String() + "#" + referring.Id
+			if !seen[key] {
+				seen[key] = true
+				merged.SubjectReferring = append(merged.SubjectReferring, referring)
+			}
+		}
+	}
+	slices.Reverse(merged.SubjectReferring)
+	return merged
+}
+
+// collapseFromRequest reads ?collapse=. On failure it has already written
+// the response.
+func collapseFromRequest(c *gin.Context) (collapse bool, ok bool) {
+	value := c.Query("collapse")
+	if value == "" {
+		return false, true
+	}
+	collapse, err := strconv.ParseBool(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "collapse must be true or false"})
+		return false, false
+	}
+	return collapse, true
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+)
+
+// seedCollapsible stores activities by a few users on a few posts, seven
+// minutes apart, with creation times set as if they had been made then.
+func seedCollapsible(t *testing.T, store *memoryStore, n int, start time.Time) {
+	t.Helper()
+	for i := range n {
+		feedId := fmt.Sprintf("feed%03d", i)
+		err := store.UpsertActivity(context.Background(), &proto.UserActivity{
+			FeedId:             feedId,
+			ActionTextTemplate: "{subject} liked {object} post.",
+			SubjectReferring:   []*proto.UserActivityReferring{{Type: proto.ReferringType_USER, Id: fmt.Sprint(i % 5)}},
+			ObjectReferring:    []*proto.UserActivityReferring{{Type: proto.ReferringType_POST, Id: fmt.Sprint(i % 3), UserId: "2"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.mu.Lock()
+		store.activities[feedId].CreatedAt = start.Add(time.Duration(i) * 7 * time.Minute).Format(createdAtLayout)
+		store.mu.Unlock()
+	}
+}
+
+func TestCollapseActivitiesSlots(t *testing.T) {
+	at := func(feedId, subject string, createdAt string) *proto.UserActivity {
+		return &proto.UserActivity{
+			FeedId:             feedId,
+			ActionTextTemplate: "{subject} liked {object} post.",
+			SubjectReferring:   []*proto.UserActivityReferring{{Type: proto.ReferringType_USER, Id: subject}},
+			ObjectReferring:    []*proto.UserActivityReferring{{Type: proto.ReferringType_POST, Id: "77", UserId: "2"}},
+			CreatedAt:          createdAt,
+		}
+	}
+	collapsed := collapseActivities([]*proto.UserActivity{
+		at("a", "1", "2024-06-01T10:05:00Z"),
+		at("b", "3", "2024-06-01T10:55:00Z"),
+		at("c", "1", "2024-06-01T11:01:00Z"),
+	}, time.Hour)
+
+	// c is only minutes after b, but in the next slot.
+	if len(collapsed) != 2 || collapsed[0].FeedId != "c" || !slices.Equal(collapsed[1].FeedIds, []string{"b", "a"}) {
+		t.Fatalf("collapsed = %v", collapsed)
+	}
+	if subjects := collapsed[1].SubjectReferring; len(subjects) != 2 || subjects[1].Id != "3" {
+		t.Fatalf("subjects = %v, want the newest last", subjects)
+	}
+}
+
+func TestListCollapsedPage(t *testing.T) {
+	store := newMemoryStore()
+	seedCollapsible(t, store, 250, time.Date(2024, 6, 1, 10, 20, 0, 0, time.UTC))
+	all, err := store.ListActivities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, activity := range collapseActivities(all, time.Hour) {
+		want = append(want, activity.FeedId)
+	}
+
+	for _, limit := range []int{1, 4, 7, 50} {
+		t.Run(fmt.Sprint(limit), func(t *testing.T) {
+			var forward []string
+			q := pageQuery{Limit: limit}
+			for {
+				page, more, err := listCollapsedPage(context.Background(), store, activityFilter{}, q, time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(page) != limit && more {
+					t.Fatalf("short page of %d with more to come", len(page))
+				}
+				for _, activity := range page {
+					forward = append(forward, activity.FeedId)
+				}
+				if !more {
+					break
+				}
+				last := activityKey(page[len(page)-1])
+				q = pageQuery{Limit: limit, After: &last}
+			}
+			if !slices.Equal(forward, want) {
+				t.Fatalf("paging forward got %v, want %v", forward, want)
+			}
+
+			// And back again from the oldest.
+			var backward []string
+			oldest := activityKey(all[0])
+			for _, activity := range all {
+				if compareActivityKeys(activityKey(activity), oldest) > 0 {
+					oldest = activityKey(activity)
+				}
+			}
+			oldest.CreatedAt = oldest.CreatedAt.Add(-time.Nanosecond)
+			q = pageQuery{Limit: limit, Before: &oldest}
+			for {
+				page, more, err := listCollapsedPage(context.Background(), store, activityFilter{}, q, time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids := make([]string, len(page))
+				for i, activity := range page {
+					ids[i] = activity.FeedId
+				}
+				backward = append(ids, backward...)
+				if !more {
+					break
+				}
+				first := activityKey(page[0])
+				q = pageQuery{Limit: limit, Before: &first}
+			}
+			if !slices.Equal(backward, want) {
+				t.Fatalf("paging backward got %v, want %v", backward, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is everything the service can be configured with. It is loaded by
+// loadConfig from, in increasing order of precedence, the defaults, a YAML
+// file, environment variables and command-line flags.
+type config struct {
+	Store       string `yaml:"store"`
+	AutoMigrate bool   `yaml:"auto-migrate"`
+	HTTPAddr    string `yaml:"http-addr"`
+	GRPCAddr    string `yaml:"grpc-addr"`
+	LocalesDir  string `yaml:"locales-dir"`
+	// ShutdownTimeout bounds how long shutdown waits for requests to drain.
+	ShutdownTimeout time.Duration    `yaml:"shutdown-timeout"`
+	Database        databaseConfig   `yaml:"database"`
+	Kafka           kafkaConfig      `yaml:"kafka"`
+	Presence        presenceConfig   `yaml:"presence"`
+	Realtime        realtimeConfig   `yaml:"realtime"`
+	Tracing         tracingConfig    `yaml:"tracing"`
+	Logging         loggingConfig    `yaml:"logging"`
+	Auth            authConfig       `yaml:"auth"`
+	Pagination      paginationConfig `yaml:"pagination"`
+	Feed            feedConfig       `yaml:"feed"`
+}
+
+type databaseConfig struct {
+	// URL, when set, is used as is instead of the settings below but the
+	// pool sizes.
+	URL             string        `yaml:"url"`
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	User            string        `yaml:"user"`
+	Password        string        `yaml:"password"`
+	Name            string        `yaml:"name"`
+	SSLMode         string        `yaml:"sslmode"`
+	SSLRootCert     string        `yaml:"sslrootcert"`
+	SSLCert         string        `yaml:"sslcert"`
+	SSLKey          string        `yaml:"sslkey"`
+	MaxOpenConns    int           `yaml:"max-open-conns"`
+	MaxIdleConns    int           `yaml:"max-idle-conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn-max-lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn-max-idle-time"`
+}
+
+type kafkaConfig struct {
+	// Brokers may be empty, in which case published activities are kept in
+	// memory and nothing is consumed.
+	Brokers         []string `yaml:"brokers"`
+	ActivitiesTopic string   `yaml:"activities-topic"`
+	AggregatedTopic string   `yaml:"aggregated-topic"`
+	GroupId         string   `yaml:"group-id"`
+}
+
+type presenceConfig struct {
+	OnlineWithin  time.Duration `yaml:"online-within"`
+	AwayWithin    time.Duration `yaml:"away-within"`
+	FlushInterval time.Duration `yaml:"flush-interval"`
+}
+
+type tracingConfig struct {
+	// Exporter is "otlp", "stdout" or "file" for local development, or
+	// "none".
+	Exporter     string  `yaml:"exporter"`
+	OTLPEndpoint string  `yaml:"otlp-endpoint"`
+	OTLPInsecure bool    `yaml:"otlp-insecure"`
+	File         string  `yaml:"file"`
+	SampleRatio  float64 `yaml:"sample-ratio"`
+	ServiceName  string  `yaml:"service-name"`
+}
+
+// loggingConfig is the logging the service starts with; both can be changed
+// at runtime through /admin/logging.
+type loggingConfig struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level"`
+	// Format is "text" or "json".
+	Format string `yaml:"format"`
+}
+
+// authConfig holds the keys bearer tokens are checked with. The service
+// refuses to start without one unless Insecure is set.
+type authConfig struct {
+	// HS256Secret verifies HS256 tokens.
+	HS256Secret string `yaml:"hs256-secret"`
+	// RS256PublicKey is a PEM file with a public key or certificate that
+	// verifies RS256 tokens.
+	RS256PublicKey string `yaml:"rs256-public-key"`
+	// JWKSFile is a JSON Web Key Set of RSA and symmetric keys, picked by
+	// the kid of the token.
+	JWKSFile string `yaml:"jwks-file"`
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string        `yaml:"issuer"`
+	Audience string        `yaml:"audience"`
+	Leeway   time.Duration `yaml:"leeway"`
+	// Insecure lets the service run with no keys, every request anonymous
+	// and allowed. It is for local development only.
+	Insecure bool `yaml:"insecure"`
+}
+
+// enabled reports whether any key is configured.
+func (a authConfig) enabled() bool {
+	return a.HS256Secret != "" || a.RS256PublicKey != "" || a.JWKSFile != ""
+}
+
+type paginationConfig struct {
+	DefaultPageSize int `yaml:"default-page-size"`
+	MaxPageSize     int `yaml:"max-page-size"`
+	// CursorSecret signs page cursors and has to be the same on every
+	// instance. Left empty, a random one is used, and cursors only work on
+	// the instance that made them until it restarts.
+	CursorSecret string `yaml:"cursor-secret"`
+}
+
+type feedConfig struct {
+	// CollapseWindow is the length of the fixed time slots activities
+	// listed with ?collapse=true are merged within.
+	CollapseWindow time.Duration `yaml:"collapse-window"`
+}
+
+type realtimeConfig struct {
+	SSEHeartbeat           time.Duration `yaml:"sse-heartbeat"`
+	ActivityChangesOverlap time.Duration `yaml:"activity-changes-overlap"`
+}
+
+func defaultConfig() *config {
+	return &config{
+		Store:           "postgres",
+		HTTPAddr:        ":8080",
+		GRPCAddr:        ":9090",
+		ShutdownTimeout: 30 * time.Second,
+		Database: databaseConfig{
+			Host:            "localhost",
+			Port:            5432,
+			User:            "postgres",
+			Password:        "postgres",
+			Name:            "postgres",
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Kafka: kafkaConfig{
+			ActivitiesTopic: "user-activities",
+			AggregatedTopic: "aggregated-activities",
+			GroupId:         "user-service-golang",
+		},
+		Presence: presenceConfig{
+			OnlineWithin:  2 * time.Minute,
+			AwayWithin:    15 * time.Minute,
+			FlushInterval: 10 * time.Second,
+		},
+		Realtime: realtimeConfig{
+			SSEHeartbeat:           15 * time.Second,
+			ActivityChangesOverlap: time.Minute,
+		},
+		Tracing: tracingConfig{
+			Exporter:     "none",
+			OTLPEndpoint: "localhost:4317",
+			File:         "traces.jsonl",
+			SampleRatio:  1,
+			ServiceName:  "user-service-golang",
+		},
+		Logging: loggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Auth: authConfig{
+			Leeway: 30 * time.Second,
+		},
+		Pagination: paginationConfig{
+			DefaultPageSize: 50,
+			MaxPageSize:     500,
+		},
+		Feed: feedConfig{
+			CollapseWindow: time.Hour,
+		},
+	}
+}
+
+// configVar binds a setting to its environment variable and flag. Secret
+// settings can also be read from the file named by <env>_FILE, as Docker
+// secrets are.
+type configVar struct {
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	// boolean flags may be given without a value.
+	boolean bool
+	set     func(string) error
+}
+
+func (c *config) vars() []configVar {
+	db := &c.Database
+	return []configVar{
+		{env: "STORE_BACKEND", flag: "store", usage: `storage backend, "postgres" or "memory"`, set: setString(&c.Store)},
+		{env: "AUTO_MIGRATE", flag: "auto-migrate", usage: "apply pending migrations on start", boolean: true, set: setBool(&c.AutoMigrate)},
+		// PORT is what gin's Run used to listen on; HTTP_ADDR wins over it.
+		{env: "PORT", set: func(s string) error { c.HTTPAddr = ":" + s; return nil }},
+		{env: "HTTP_ADDR", flag: "http-addr", usage: "HTTP listen address", set: setString(&c.HTTPAddr)},
+		{env: "GRPC_ADDR", flag: "grpc-addr", usage: "gRPC listen address", set: setString(&c.GRPCAddr)},
+		{env: "SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", usage: "how long to wait for requests to drain on shutdown", set: setDuration(&c.ShutdownTimeout)},
+		{env: "LOCALES_DIR", flag: "locales-dir", usage: "directory of locale catalogs overriding the built-in ones", set: setString(&c.LocalesDir)},
+
+		{env: "DATABASE_URL", flag: "database-url", usage: "Postgres URL, overriding the other database settings", secret: true, set: setString(&db.URL)},
+		{env: "DB_HOST", flag: "db-host", usage: "Postgres host", set: setString(&db.Host)},
+		{env: "DB_PORT", flag: "db-port", usage: "Postgres port", set: setInt(&db.Port)},
+		{env: "DB_USER", flag: "db-user", usage: "Postgres user", set: setString(&db.User)},
+		{env: "DB_PASSWORD", usage: "Postgres password", secret: true, set: setString(&db.Password)},
+		{env: "DB_NAME", flag: "db-name", usage: "Postgres database", set: setString(&db.Name)},
+		{env: "DB_SSLMODE", flag: "db-sslmode", usage: "Postgres sslmode", set: setString(&db.SSLMode)},
+		{env: "DB_SSLROOTCERT", flag: "db-sslrootcert", usage: "CA certificate file to verify Postgres with", set: setString(&db.SSLRootCert)},
+		{env: "DB_SSLCERT", flag: "db-sslcert", usage: "client certificate file for Postgres", set: setString(&db.SSLCert)},
+		{env: "DB_SSLKEY", flag: "db-sslkey", usage: "client key file for Postgres", set: setString(&db.SSLKey)},
+		{env: "DB_MAX_OPEN_CONNS", flag: "db-max-open-conns", usage: "most open Postgres connections, 0 for no limit", set: setInt(&db.MaxOpenConns)},
+		{env: "DB_MAX_IDLE_CONNS", flag: "db-max-idle-conns", usage: "most idle Postgres connections", set: setInt(&db.MaxIdleConns)},
+		{env: "DB_CONN_MAX_LIFETIME", flag: "db-conn-max-lifetime", usage: "longest a Postgres connection is reused, 0 for ever", set: setDuration(&db.ConnMaxLifetime)},
+		{env: "DB_CONN_MAX_IDLE_TIME", flag: "db-conn-max-idle-time", usage: "longest a Postgres connection stays idle, 0 for ever", set: setDuration(&db.ConnMaxIdleTime)},
+
+		{env: "KAFKA_BROKERS", flag: "kafka-brokers", usage: "comma-separated Kafka brokers", set: setList(&c.Kafka.Brokers)},
+		{env: "KAFKA_ACTIVITIES_TOPIC", flag: "kafka-activities-topic", usage: "topic new activities are published to", set: setString(&c.Kafka.ActivitiesTopic)},
+		{env: "KAFKA_AGGREGATED_TOPIC", flag: "kafka-aggregated-topic", usage: "topic aggregated activities are consumed from", set: setString(&c.Kafka.AggregatedTopic)},
+		{env: "KAFKA_GROUP_ID", flag: "kafka-group-id", usage: "consumer group for aggregated activities", set: setString(&c.Kafka.GroupId)},
+
+		{env: "PRESENCE_ONLINE_WITHIN", usage: "how recent a heartbeat keeps a user online", set: setDuration(&c.Presence.OnlineWithin)},
+		{env: "PRESENCE_AWAY_WITHIN", usage: "how recent a heartbeat keeps a user away", set: setDuration(&c.Presence.AwayWithin)},
+		{env: "HEARTBEAT_FLUSH_INTERVAL", usage: "how often heartbeats are written to the store", set: setDuration(&c.Presence.FlushInterval)},
+		{env: "SSE_HEARTBEAT_INTERVAL", usage: "how often idle event streams get a heartbeat", set: setDuration(&c.Realtime.SSEHeartbeat)},
+		{env: "TRACING_EXPORTER", flag: "tracing-exporter", usage: `trace exporter, "otlp", "stdout", "file" or "none"`, set: setString(&c.Tracing.Exporter)},
+		{env: "OTEL_EXPORTER_OTLP_ENDPOINT", flag: "otlp-endpoint", usage: "OTLP gRPC collector host:port", set: setOTLPEndpoint(&c.Tracing.OTLPEndpoint, &c.Tracing.OTLPInsecure)},
+		{env: "OTEL_EXPORTER_OTLP_INSECURE", usage: "talk to the OTLP collector without TLS", set: setBool(&c.Tracing.OTLPInsecure)},
+		{env: "TRACING_FILE", flag: "tracing-file", usage: "file the file exporter appends spans to", set: setString(&c.Tracing.File)},
+		{env: "TRACING_SAMPLE_RATIO", flag: "tracing-sample-ratio", usage: "fraction of new traces to sample", set: setFloat(&c.Tracing.SampleRatio)},
+		{env: "OTEL_SERVICE_NAME", usage: "service name spans are reported under", set: setString(&c.Tracing.ServiceName)},
+		{env: "LOG_LEVEL", flag: "log-level", usage: `log level, "debug", "info", "warn" or "error"`, set: setString(&c.Logging.Level)},
+		{env: "LOG_FORMAT", flag: "log-format", usage: `log format, "text" or "json"`, set: setString(&c.Logging.Format)},
+		{env: "AUTH_HS256_SECRET", usage: "secret HS256 bearer tokens are signed with", secret: true, set: setString(&c.Auth.HS256Secret)},
+		{env: "AUTH_RS256_PUBLIC_KEY", flag: "auth-rs256-public-key", usage: "PEM file of the key RS256 bearer tokens are signed with", set: setString(&c.Auth.RS256PublicKey)},
+		{env: "AUTH_JWKS_FILE", flag: "auth-jwks-file", usage: "JSON Web Key Set file to verify bearer tokens with", set: setString(&c.Auth.JWKSFile)},
+		{env: "AUTH_ISSUER", flag: "auth-issuer", usage: "required iss of bearer tokens", set: setString(&c.Auth.Issuer)},
+		{env: "AUTH_AUDIENCE", flag: "auth-audience", usage: "required aud of bearer tokens", set: setString(&c.Auth.Audience)},
+		{env: "AUTH_LEEWAY", usage: "clock skew allowed when checking token times", set: setDuration(&c.Auth.Leeway)},
+		{env: "AUTH_INSECURE", flag: "auth-insecure", usage: "serve every request anonymously when no keys are configured", boolean: true, set: setBool(&c.Auth.Insecure)},
+		{env: "DEFAULT_PAGE_SIZE", flag: "default-page-size", usage: "page size of lists when the client asks for none", set: setInt(&c.Pagination.DefaultPageSize)},
+		{env: "MAX_PAGE_SIZE", flag: "max-page-size", usage: "largest page size clients may ask for", set: setInt(&c.Pagination.MaxPageSize)},
+		{env: "CURSOR_SECRET", usage: "secret page cursors are signed with, the same on every instance", secret: true, set: setString(&c.Pagination.CursorSecret)},
+		{env: "FEED_COLLAPSE_WINDOW", flag: "feed-collapse-window", usage: "length of the time slots activities are collapsed within", set: setDuration(&c.Feed.CollapseWindow)},
+		{env: "ACTIVITY_CHANGES_OVERLAP", usage: "how far back to look again when catching up on activity changes", set: setDuration(&c.Realtime.ActivityChangesOverlap)},
+	}
+}
+
+func setString(p *string) func(string) error {
+	return func(s string) error { *p = s; return nil }
+}
+
+func setInt(p *int) func(string) error {
+	return func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*p = n
+		return nil
+	}
+}
+
+func setFloat(p *float64) func(string) error {
+	return func(s string) error {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*p = f
+		return nil
+	}
+}
+
+// setOTLPEndpoint takes an endpoint as host:port or, like the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT, as a URL whose http scheme means no TLS.
+func setOTLPEndpoint(endpoint *string, insecure *bool) func(string) error {
+	return func(s string) error {
+		if u, err := url.Parse(s); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			*endpoint = u.Host
+			*insecure = u.Scheme == "http"
+			return nil
+		}
+		*endpoint = s
+		return nil
+	}
+}
+
+func setBool(p *bool) func(string) error {
+	return func(s string) error {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*p = b
+		return nil
+	}
+}
+
+func setDuration(p *time.Duration) func(string) error {
+	return func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*p = d
+		return nil
+	}
+}
+
+func setList(p *[]string) func(string) error {
+	return func(s string) error {
+		*p = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+		return nil
+	}
+}
+
+// loadConfig loads the configuration. The YAML file is named by -config or
+// CONFIG_FILE. It returns the arguments left after the flags, and with
+// -print-config set, whether to print the configuration and exit.
+func loadConfig(args []string, getenv func(string) string) (c *config, rest []string, printOnly bool, err error) {
+	c = defaultConfig()
+	vars := c.vars()
+
+	// Flags are parsed first to find the config file, but applied last.
+	type flagValue struct {
+		v     configVar
+		value string
+	}
+	var flagged []flagValue
+	fs := flag.NewFlagSet("user-service-golang", flag.ContinueOnError)
+	configFile := fs.String("config", getenv("CONFIG_FILE"), "YAML configuration file")
+	fs.BoolVar(&printOnly, "print-config", false, "print the effective configuration, secrets redacted, and exit")
+	for _, v := range vars {
+		if v.flag == "" {
+			continue
+		}
+		record := func(s string) error {
+			flagged = append(flagged, flagValue{v, s})
+			return nil
+		}
+		if v.boolean {
+			fs.BoolFunc(v.flag, v.usage+" (env "+v.env+")", record)
+		} else {
+			fs.Func(v.flag, v.usage+" (env "+v.env+")", record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, false, err
+	}
+
+	if *configFile != "" {
+		if err := c.loadYAML(*configFile); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	for _, v := range vars {
+		value := getenv(v.env)
+		if file := getenv(v.env + "_FILE"); v.secret && file != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, nil, false, fmt.Errorf("%s_FILE: %w", v.env, err)
+			}
+			value = strings.TrimRight(string(data), "\r\n")
+		}
+		if value == "" {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			return nil, nil, false, fmt.Errorf("%s: %w", v.env, err)
+		}
+	}
+
+	for _, f := range flagged {
+		if err := f.v.set(f.value); err != nil {
+			return nil, nil, false, fmt.Errorf("-%s: %w", f.v.flag, err)
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, nil, false, err
+	}
+	return c, fs.Args(), printOnly, nil
+}
+
+func (c *config) loadYAML(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// validate reports every problem with the configuration at once.
+func (c *config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Store == "postgres" || c.Store == "memory", "store must be postgres or memory, not %q", c.Store)
+	for name, addr := range map[string]string{"http-addr": c.HTTPAddr, "grpc-addr": c.GRPCAddr} {
+		_, port, err := net.SplitHostPort(addr)
+		check(err == nil && port != "", "%s %q is not a host:port address", name, addr)
+	}
+	check(c.ShutdownTimeout > 0, "shutdown-timeout must be positive")
+	check(c.HTTPAddr != c.GRPCAddr, "http-addr and grpc-addr are both %q", c.HTTPAddr)
+
+	if c.Store == "postgres" {
+		db := c.Database
+		if db.URL != "" {
+			u, err := url.Parse(db.URL)
+			check(err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql"), "database.url is not a postgres:// URL")
+		} else {
+			check(db.Host != "", "database.host is required")
+			check(db.Port > 0 && db.Port < 65536, "database.port %d is out of range", db.Port)
+			check(db.User != "", "database.user is required")
+			check(db.Name != "", "database.name is required")
+			check(slices.Contains(sslModes, db.SSLMode), "database.sslmode must be one of %s, not %q", strings.Join(sslModes, ", "), db.SSLMode)
+			check((db.SSLCert == "") == (db.SSLKey == ""), "database.sslcert and database.sslkey go together")
+			for name, file := range map[string]string{"sslrootcert": db.SSLRootCert, "sslcert": db.SSLCert, "sslkey": db.SSLKey} {
+				if file != "" {
+					_, err := os.Stat(file)
+					check(err == nil, "database.%s: %v", name, err)
+				}
+			}
+		}
+		check(db.MaxOpenConns >= 0, "database.max-open-conns must not be negative")
+		check(db.MaxIdleConns >= 0, "database.max-idle-conns must not be negative")
+		check(db.MaxOpenConns == 0 || db.MaxIdleConns <= db.MaxOpenConns, "database.max-idle-conns is more than database.max-open-conns")
+		check(db.ConnMaxLifetime >= 0, "database.conn-max-lifetime must not be negative")
+		check(db.ConnMaxIdleTime >= 0, "database.conn-max-idle-time must not be negative")
+	}
+
+	check(c.Kafka.ActivitiesTopic != "", "kafka.activities-topic is required")
+	check(c.Kafka.AggregatedTopic != "", "kafka.aggregated-topic is required")
+	check(c.Kafka.GroupId != "", "kafka.group-id is required")
+
+	check(c.Presence.OnlineWithin > 0, "presence.online-within must be positive")
+	check(c.Presence.AwayWithin > c.Presence.OnlineWithin, "presence.away-within must be longer than presence.online-within")
+	check(c.Presence.FlushInterval > 0, "presence.flush-interval must be positive")
+	check(c.Realtime.SSEHeartbeat > 0, "realtime.sse-heartbeat must be positive")
+	check(slices.Contains([]string{"otlp", "stdout", "file", "none"}, c.Tracing.Exporter), "tracing.exporter must be otlp, stdout, file or none, not %q", c.Tracing.Exporter)
+	check(c.Tracing.Exporter != "otlp" || c.Tracing.OTLPEndpoint != "", "tracing.otlp-endpoint is required with the otlp exporter")
+	check(c.Tracing.Exporter != "file" || c.Tracing.File != "", "tracing.file is required with the file exporter")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample-ratio must be between 0 and 1")
+	check(c.Tracing.ServiceName != "", "tracing.service-name is required")
+	check(c.Realtime.ActivityChangesOverlap >= 0, "realtime.activity-changes-overlap must not be negative")
+	_, err := parseLogLevel(c.Logging.Level)
+	check(err == nil, "logging.level must be debug, info, warn or error, not %q", c.Logging.Level)
+	check(c.Logging.Format == "text" || c.Logging.Format == "json", "logging.format must be text or json, not %q", c.Logging.Format)
+	check(c.Auth.HS256Secret == "" || len(c.Auth.HS256Secret) >= 32, "auth.hs256-secret must be at least 32 bytes")
+	for name, file := range map[string]string{"rs256-public-key": c.Auth.RS256PublicKey, "jwks-file": c.Auth.JWKSFile} {
+		if file != "" {
+			_, err := os.Stat(file)
+			check(err == nil, "auth.%s: %v", name, err)
+		}
+	}
+	check(c.Auth.Leeway >= 0, "auth.leeway must not be negative")
+	check(c.Auth.enabled() || c.Auth.Insecure, "auth needs a key, or auth.insecure to serve without authentication")
+	check(c.Pagination.DefaultPageSize > 0, "pagination.default-page-size must be positive")
+	check(c.Pagination.MaxPageSize >= c.Pagination.DefaultPageSize, "pagination.max-page-size is less than pagination.default-page-size")
+	check(c.Feed.CollapseWindow > 0, "feed.collapse-window must be positive")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Redacted renders the configuration as YAML with secrets masked.
+func (c *config) Redacted() string {
+	redacted := *c
+	if redacted.Database.Password != "" {
+		redacted.Database.Password = "REDACTED"
+	}
+	if redacted.Pagination.CursorSecret != "" {
+		redacted.Pagination.CursorSecret = "REDACTED"
+	}
+	if redacted.Auth.HS256Secret != "" {
+		redacted.Auth.HS256Secret = "REDACTED"
+	}
+	if redacted.Database.URL != "" {
+		if u, err := url.Parse(redacted.Database.URL); err == nil {
+			// lib/pq also takes the password, and the client key's, as
+			// query parameters.
+			q := u.Query()
+			for _, key := range []string{"password", "sslpassword"} {
+				if q.Has(key) {
+					q.Set(key, "REDACTED")
+					u.RawQuery = q.Encode()
+				}
+			}
+			redacted.Database.URL = u.Redacted()
+		} else {
+			redacted.Database.URL = "REDACTED"
+		}
+	}
+	data, err := yaml.Marshal(&redacted)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// dsn is the lib/pq connection string.
+func (db databaseConfig) dsn() string {
+	if db.URL != "" {
+		return db.URL
+	}
+	params := []struct{ key, value string }{
+		{"host", db.Host},
+		{"port", strconv.Itoa(db.Port)},
+		{"user", db.User},
+		{"password", db.Password},
+		{"dbname", db.Name},
+		{"sslmode", db.SSLMode},
+		{"sslrootcert", db.SSLRootCert},
+		{"sslcert", db.SSLCert},
+		{"sslkey", db.SSLKey},
+	}
+	var b strings.Builder
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		// Values are quoted so that spaces and quotes in them survive.
+		b.WriteString(p.key + "='" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(p.value) + "'")
+	}
+	return b.String()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// environment is a getenv over a fixed set of variables.
+func environment(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+// writeFile writes data to name in a temporary directory and returns its
+// path.
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+http-addr: ":8001"
+grpc-addr: ":9001"
+logging:
+  level: debug
+  format: json
+auth:
+  insecure: true
+`)
+	c, rest, printOnly, err := loadConfig(
+		[]string{"-config", file, "-log-level", "error", "-auto-migrate", "migrate"},
+		environment(map[string]string{"GRPC_ADDR": ":9002", "LOG_LEVEL": "warn", "AUTO_MIGRATE": "false"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, test := range map[string]struct{ got, want any }{
+		"default":       {c.ShutdownTimeout, 30 * time.Second},
+		"yaml":          {c.HTTPAddr, ":8001"},
+		"yaml nested":   {c.Logging.Format, "json"},
+		"env over yaml": {c.GRPCAddr, ":9002"},
+		"flag over env": {c.Logging.Level, "error"},
+		"boolean flag":  {c.AutoMigrate, true},
+		"arguments":     {strings.Join(rest, " "), "migrate"},
+		"print-config":  {printOnly, false},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", name, test.got, test.want)
+		}
+	}
+}
+
+func TestLoadConfigFromEnvOnly(t *testing.T) {
+	c, _, _, err := loadConfig(nil, environment(map[string]string{
+		"CONFIG_FILE":   writeFile(t, "config.yaml", "http-addr: \":8001\"\n"),
+		"PORT":          "3000",
+		"AUTH_INSECURE": "true",
+		"KAFKA_BROKERS": "kafka1:9092, kafka2:9092,",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.HTTPAddr != ":3000" {
+		t.Errorf("http-addr = %q, want PORT over the file", c.HTTPAddr)
+	}
+	if got := strings.Join(c.Kafka.Brokers, " "); got != "kafka1:9092 kafka2:9092" {
+		t.Errorf("brokers = %q", got)
+	}
+}
+
+func TestLoadConfigSecretFiles(t *testing.T) {
+	secret := strings.Repeat("s", 32)
+	c, _, _, err := loadConfig(nil, environment(map[string]string{
+		"DB_PASSWORD":            "from-env",
+		"DB_PASSWORD_FILE":       writeFile(t, "db-password", "from-file\n"),
+		"AUTH_HS256_SECRET_FILE": writeFile(t, "hs256-secret", secret+"\r\n"),
+		// Only secrets are read from files.
+		"DB_HOST_FILE": writeFile(t, "db-host", "db.example.com"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Database.Password != "from-file" {
+		t.Errorf("password = %q, want the file's, without the newline", c.Database.Password)
+	}
+	if c.Auth.HS256Secret != secret {
+		t.Errorf("hs256 secret = %q", c.Auth.HS256Secret)
+	}
+	if c.Database.Host != "localhost" {
+		t.Errorf("host = %q, want the default", c.Database.Host)
+	}
+
+	_, _, _, err = loadConfig(nil, environment(map[string]string{
+		"AUTH_INSECURE":    "true",
+		"DB_PASSWORD_FILE": filepath.Join(t.TempDir(), "missing"),
+	}))
+	if err == nil || !strings.HasPrefix(err.Error(), "DB_PASSWORD_FILE: ") {
+		t.Fatalf("err = %v, want the missing file reported", err)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		args []string
+		env  map[string]string
+		want string
+	}{
+		"bad env value":    {nil, map[string]string{"DB_PORT": "five"}, "DB_PORT: "},
+		"bad flag value":   {[]string{"-shutdown-timeout", "soon"}, nil, "-shutdown-timeout: "},
+		"unknown yaml key": {[]string{"-config", writeFile(t, "config.yaml", "no-such-key: 1\n")}, nil, "field no-such-key not found"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, _, err := loadConfig(test.args, environment(test.env))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("err = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for name, test := range map[string]struct {
+		change func(c *config)
+		want   string
+	}{
+		"store":           {func(c *config) { c.Store = "redis" }, `store must be postgres or memory, not "redis"`},
+		"address":         {func(c *config) { c.HTTPAddr = "8080" }, `http-addr "8080" is not a host:port address`},
+		"same addresses":  {func(c *config) { c.GRPCAddr = c.HTTPAddr }, `http-addr and grpc-addr are both ":8080"`},
+		"database url":    {func(c *config) { c.Database.URL = "mysql://db/users" }, "database.url is not a postgres:// URL"},
+		"database port":   {func(c *config) { c.Database.Port = 70000 }, "database.port 70000 is out of range"},
+		"sslmode":         {func(c *config) { c.Database.SSLMode = "always" }, "database.sslmode must be one of"},
+		"ssl key pair":    {func(c *config) { c.Database.SSLCert = "client.crt" }, "database.sslcert and database.sslkey go together"},
+		"idle conns":      {func(c *config) { c.Database.MaxOpenConns = 2 }, "database.max-idle-conns is more than database.max-open-conns"},
+		"presence":        {func(c *config) { c.Presence.AwayWithin = c.Presence.OnlineWithin }, "presence.away-within must be longer than presence.online-within"},
+		"exporter":        {func(c *config) { c.Tracing.Exporter = "jaeger" }, `tracing.exporter must be otlp, stdout, file or none, not "jaeger"`},
+		"sample ratio":    {func(c *config) { c.Tracing.SampleRatio = 2 }, "tracing.sample-ratio must be between 0 and 1"},
+		"log level":       {func(c *config) { c.Logging.Level = "loud" }, `logging.level must be debug, info, warn or error, not "loud"`},
+		"short secret":    {func(c *config) { c.Auth.HS256Secret = "secret" }, "auth.hs256-secret must be at least 32 bytes"},
+		"missing key":     {func(c *config) { c.Auth.JWKSFile = filepath.Join(t.TempDir(), "jwks.json") }, "auth.jwks-file: "},
+		"no auth":         {func(c *config) { c.Auth.Insecure = false }, "auth needs a key, or auth.insecure to serve without authentication"},
+		"page sizes":      {func(c *config) { c.Pagination.MaxPageSize = 10 }, "pagination.max-page-size is less than pagination.default-page-size"},
+		"collapse window": {func(c *config) { c.Feed.CollapseWindow = 0 }, "feed.collapse-window must be positive"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := defaultConfig()
+			c.Auth.Insecure = true
+			if err := c.validate(); err != nil {
+				t.Fatalf("defaults: %v", err)
+			}
+			test.change(c)
+			err := c.validate()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("err = %v, want %q", err, test.want)
+			}
+		})
+	}
+
+	// Every problem is reported at once.
+	c := defaultConfig()
+	c.Store, c.Logging.Format = "redis", "xml"
+	err := c.validate()
+	if err == nil || !strings.Contains(err.Error(), "store must be") || !strings.Contains(err.Error(), "logging.format must be") || !strings.Contains(err.Error(), "auth needs a key") {
+		t.Fatalf("err = %v, want all three problems", err)
+	}
+
+	// The database settings are not checked for the memory store.
+	c = defaultConfig()
+	c.Auth.Insecure = true
+	c.Store, c.Database.Host = "memory", ""
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	c := defaultConfig()
+	c.Database.Password = "pw-db"
+	c.Database.URL = "postgres://app:pw-url@db:5432/users?sslmode=verify-full&password=pw-query&sslpassword=pw-key"
+	c.Auth.HS256Secret = "pw-hs256"
+	c.Pagination.CursorSecret = "pw-cursor"
+	c.Kafka.GroupId = "group"
+
+	out := c.Redacted()
+	for _, secret := range []string{"pw-db", "pw-url", "pw-query", "pw-key", "pw-hs256", "pw-cursor"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("%s shown in:\n%s", secret, out)
+		}
+	}
+	for _, kept := range []string{"app:xxxxx@db:5432/users", "sslmode=verify-full", "group-id: group", "host: localhost"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("%s missing from:\n%s", kept, out)
+		}
+	}
+	if c.Database.Password != "pw-db" || !strings.Contains(c.Database.URL, "pw-url") {
+		t.Fatal("Redacted changed the configuration")
+	}
+}
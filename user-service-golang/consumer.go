@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// sourceMessage is one message read from an ActivitySource.
+type sourceMessage struct {
+	Key   []byte
+	Value []byte
+	// Offset identifies the message within its source for logging.
+	Offset int64
+	// Headers may carry the trace context of whoever produced the message.
+	Headers map[string]string
+
+	kafka kafka.Message
+}
+
+// ActivitySource feeds aggregated activities to the consumer. Fetch blocks
+// until a message is available or ctx is done; Commit acknowledges a message
+// and everything fetched before it.
+type ActivitySource interface {
+	Fetch(ctx context.Context) (sourceMessage, error)
+	Commit(ctx context.Context, msg sourceMessage) error
+	Close() error
+}
+
+var activityUnmarshaler = protojson.UnmarshalOptions{DiscardUnknown: true}
+
+// activityConsumer upserts aggregated activities from a source into the read
+// model. Messages are committed only once stored, so delivery is
+// at-least-once; the upsert is idempotent, so replays are harmless.
+type activityConsumer struct {
+	source     ActivitySource
+	activities ActivityStore
+	maxBackoff time.Duration
+	// onStored, if set, is told about each activity once it is stored.
+	onStored func(*proto.UserActivity)
+}
+
+func newActivityConsumer(source ActivitySource, activities ActivityStore) *activityConsumer {
+	return &activityConsumer{source: source, activities: activities, maxBackoff: 30 * time.Second}
+}
+
+// Run consumes until ctx is done. Failures to fetch, store or commit are
+// retried with backoff rather than given up on, since the consumer has no
+// one to hand them to. The message being handled when ctx ends is left
+// uncommitted and is redelivered on the next start.
+func (c *activityConsumer) Run(ctx context.Context) {
+	for {
+		var msg sourceMessage
+		err := c.retry(ctx, func() (err error) {
+			msg, err = c.source.Fetch(ctx)
+			return err
+		}, "Failed to fetch aggregated activity")
+		if err != nil {
+			return
+		}
+
+		if err := c.handle(ctx, msg); err != nil {
+			return
+		}
+
+		err = c.retry(ctx, func() error {
+			return c.source.Commit(ctx, msg)
+		}, "Failed to commit aggregated activity", "offset", msg.Offset)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// retry calls op until it succeeds, logging each failure with args and
+// waiting between attempts for a backoff that doubles up to maxBackoff. It
+// fails only once ctx is done.
+func (c *activityConsumer) retry(ctx context.Context, op func() error, msg string, args ...any) error {
+	backoff := 100 * time.Millisecond
+	for {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.ErrorContext(ctx, msg, append(args, "retry_in", backoff, "error", err)...)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, c.maxBackoff)
+	}
+}
+
+// handle stores one message, retrying store failures with backoff until
+// ctx is done. Messages that can't be decoded, or that the store rejects,
+// are logged and skipped, as AggregatedUserActivityListener does, since
+// retrying them would hold up the partition for good.
+func (c *activityConsumer) handle(ctx context.Context, msg sourceMessage) (err error) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(msg.Headers))
+	ctx, span := tracer.Start(ctx, "process aggregated activity", trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+		semconv.MessagingSystemKafka,
+		semconv.MessagingOperationTypeProcess,
+	))
+	defer func() { endSpan(span, err) }()
+
+	activity, err := decodeAggregatedActivity(msg)
+	if err != nil {
+		slog.WarnContext(ctx, "Skipping aggregated activity", "offset", msg.Offset, "error", err)
+		return nil
+	}
+
+	stored := false
+	err = c.retry(ctx, func() error {
+		err := c.activities.UpsertActivity(ctx, activity)
+		if errors.Is(err, ErrInvalid) {
+			slog.ErrorContext(ctx, "Skipping aggregated activity the store rejects", "offset", msg.Offset, "feed_id", activity.FeedId, "error", err)
+			return nil
+		}
+		stored = err == nil
+		return err
+	}, "Failed to upsert aggregated activity", "feed_id", activity.FeedId)
+	if stored && c.onStored != nil {
+		c.onStored(activity)
+	}
+	return err
+}
+
+// decodeAggregatedActivity parses and validates a protojson activity. The
+// Java reducer doesn't carry feedId over into merged activities, so one is
+// derived from the similarity key the message is keyed by.
+func decodeAggregatedActivity(msg sourceMessage) (*proto.UserActivity, error) {
+	var activity proto.UserActivity
+	if err := activityUnmarshaler.Unmarshal(msg.Value, &activity); err != nil {
+		return nil, err
+	}
+	if activity.FeedId == "" {
+		if len(msg.Key) == 0 {
+			return nil, errors.New("no feedId and no key")
+		}
+		sum := sha256.Sum256(msg.Key)
+		activity.FeedId = "agg-" + hex.EncodeToString(sum[:8])
+	}
+	if err := validateActivity(&activity); err != nil {
+		return nil, err
+	}
+	return &activity, nil
+}
+
+type kafkaSource struct {
+	reader *kafka.Reader
+}
+
+func newKafkaSource(brokers []string, topic, groupId string) *kafkaSource {
+	return &kafkaSource{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: brokers,
+			Topic:   topic,
+			GroupID: groupId,
+		}),
+	}
+}
+
+func (s *kafkaSource) Fetch(ctx context.Context) (sourceMessage, error) {
+	m, err := s.reader.FetchMessage(ctx)
+	if err != nil {
+		return sourceMessage{}, err
+	}
+	headers := make(map[string]string, len(m.Headers))
+	for _, header := range m.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	return sourceMessage{Key: m.Key, Value: m.Value, Offset: m.Offset, Headers: headers, kafka: m}, nil
+}
+
+func (s *kafkaSource) Commit(ctx context.Context, msg sourceMessage) error {
+	return s.reader.CommitMessages(ctx, msg.kafka)
+}
+
+func (s *kafkaSource) Close() error {
+	return s.reader.Close()
+}
+
+// memorySource is an in-memory feed of messages.
+type memorySource struct {
+	messages chan sourceMessage
+
+	mu        sync.Mutex
+	next      int64
+	committed int64
+}
+
+func newMemorySource() *memorySource {
+	return &memorySource{messages: make(chan sourceMessage, 64), committed: -1}
+}
+
+// Feed queues a message, blocking while the feed is full.
+func (s *memorySource) Feed(key, value []byte) {
+	s.mu.Lock()
+	offset := s.next
+	s.next++
+	s.mu.Unlock()
+	s.messages <- sourceMessage{Key: key, Value: value, Offset: offset}
+}
+
+func (s *memorySource) Fetch(ctx context.Context) (sourceMessage, error) {
+	select {
+	case <-ctx.Done():
+		return sourceMessage{}, ctx.Err()
+	case msg := <-s.messages:
+		return msg, nil
+	}
+}
+
+func (s *memorySource) Commit(ctx context.Context, msg sourceMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.committed = max(s.committed, msg.Offset)
+	return nil
+}
+
+// Committed returns the highest committed offset, or -1.
+func (s *memorySource) Committed() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.committed
+}
+
+func (s *memorySource) Close() error {
+	return nil
+}
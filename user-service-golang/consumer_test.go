@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+)
+
+// startConsumer runs a consumer of source into activities until the test
+// calls the returned stop, which waits for Run to return.
+func startConsumer(t *testing.T, source ActivitySource, activities ActivityStore) (stop func()) {
+	t.Helper()
+	consumer := newActivityConsumer(source, activities)
+	consumer.maxBackoff = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Run(ctx)
+	}()
+	t.Cleanup(cancel)
+	return func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("consumer did not stop")
+		}
+	}
+}
+
+// waitCommitted waits until source has committed offset.
+func waitCommitted(t *testing.T, source *memorySource, offset int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for source.Committed() < offset {
+		if time.Now().After(deadline) {
+			t.Fatalf("committed offset %d, want %d", source.Committed(), offset)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConsumerDuplicateReferrings(t *testing.T) {
+	source, store := newMemorySource(), newMemoryStore()
+	stop := startConsumer(t, source, store)
+
+	// What the reducer makes of two activities by user 1 on the same post.
+	source.Feed([]byte("USER#1:{subject} liked {object} post."), []byte(`{
+		"feedId": "agg1",
+		"actionTextTemplate": "{subject} liked {object} post.",
+		"subjectReferring": [{"type": "USER", "id": "1"}, {"type": "USER", "id": "1"}],
+		"objectReferring": [{"type": "POST", "id": "77", "userId": "2"}]
+	}`))
+	waitCommitted(t, source, 0)
+	stop()
+
+	activity, err := store.GetActivity(context.Background(), "agg1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activity.SubjectReferring) != 1 {
+		t.Fatalf("subjects = %v, want user 1 once", activity.SubjectReferring)
+	}
+}
+
+// rejectingStore rejects the activity with feed id "bad" as Postgres would
+// an integrity constraint violation.
+type rejectingStore struct {
+	*memoryStore
+}
+
+func (s rejectingStore) UpsertActivity(ctx context.Context, activity *proto.UserActivity) error {
+	if activity.FeedId == "bad" {
+		return fmt.Errorf("%w: violates check constraint", ErrInvalid)
+	}
+	return s.memoryStore.UpsertActivity(ctx, activity)
+}
+
+func TestConsumerSkipsRejectedActivities(t *testing.T) {
+	source, store := newMemorySource(), newMemoryStore()
+	stop := startConsumer(t, source, rejectingStore{store})
+
+	source.Feed([]byte("k"), []byte(`{"feedId": "bad", "actionTextTemplate": "{subject} waved."}`))
+	source.Feed([]byte("k"), []byte(`{"feedId": "", "subjectReferring": [{"type": "USER"}]}`))
+	source.Feed([]byte("k"), []byte(`{"feedId": "good", "actionTextTemplate": "{subject} waved."}`))
+	waitCommitted(t, source, 2)
+	stop()
+
+	if _, err := store.GetActivity(context.Background(), "good"); err != nil {
+		t.Fatalf("the activity after the rejected ones wasn't stored: %v", err)
+	}
+}
+
+func TestPublishAndConsume(t *testing.T) {
+	publisher := newMemoryPublisher("user-activities", 10)
+	for _, feedId := range []string{"like1", "like2"} {
+		err := publisher.Publish(context.Background(), &proto.UserActivity{
+			FeedId:             feedId,
+			ActionTextTemplate: "{subject} liked {object} post.",
+			SubjectReferring:   []*proto.UserActivityReferring{{Type: proto.ReferringType_USER, Id: "1"}},
+			ObjectReferring:    []*proto.UserActivityReferring{{Type: proto.ReferringType_POST, Id: "77", UserId: "2"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source, store := newMemorySource(), newMemoryStore()
+	consumer := newActivityConsumer(source, store)
+	stored := make(chan string, 2)
+	consumer.onStored = func(activity *proto.UserActivity) { stored <- activity.FeedId }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Run(ctx)
+	}()
+
+	// The stream processor would aggregate in between; passing the messages
+	// straight through checks both ends speak the same format.
+	for _, msg := range publisher.Messages() {
+		source.Feed(msg.Key, msg.Value)
+	}
+	waitCommitted(t, source, 1)
+	for _, want := range []string{"like1", "like2"} {
+		if got := <-stored; got != want {
+			t.Fatalf("stored %s, want %s", got, want)
+		}
+	}
+	activity, err := store.GetActivity(context.Background(), "like2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activity.ObjectReferring[0].UserId != "2" {
+		t.Fatalf("object = %v", activity.ObjectReferring[0])
+	}
+
+	cancel()
+	<-done
+}
+
+// failingStore fails every write, as a store that is down does.
+type failingStore struct {
+	*memoryStore
+	attempts chan struct{}
+}
+
+func (s failingStore) UpsertActivity(ctx context.Context, activity *proto.UserActivity) error {
+	select {
+	case s.attempts <- struct{}{}:
+	default:
+	}
+	return fmt.Errorf("connection refused")
+}
+
+func TestConsumerShutdownLeavesMessageUncommitted(t *testing.T) {
+	source := newMemorySource()
+	store := failingStore{memoryStore: newMemoryStore(), attempts: make(chan struct{}, 1)}
+	stop := startConsumer(t, source, store)
+
+	source.Feed([]byte("k"), []byte(`{"feedId": "feed1", "actionTextTemplate": "{subject} waved."}`))
+	<-store.attempts
+	stop()
+	// The message is redelivered on the next start.
+	if committed := source.Committed(); committed != -1 {
+		t.Fatalf("committed offset %d while the write kept failing", committed)
+	}
+}
+
+// flakySource fails the first fetches and commits, as a source does while
+// the brokers are unreachable.
+type flakySource struct {
+	*memorySource
+	fetchFailures, commitFailures atomic.Int32
+}
+
+func (s *flakySource) Fetch(ctx context.Context) (sourceMessage, error) {
+	if s.fetchFailures.Add(-1) >= 0 {
+		return sourceMessage{}, errors.New("connection refused")
+	}
+	return s.memorySource.Fetch(ctx)
+}
+
+func (s *flakySource) Commit(ctx context.Context, msg sourceMessage) error {
+	if s.commitFailures.Add(-1) >= 0 {
+		return errors.New("connection refused")
+	}
+	return s.memorySource.Commit(ctx, msg)
+}
+
+func TestConsumerRetriesFetchAndCommit(t *testing.T) {
+	source, store := &flakySource{memorySource: newMemorySource()}, newMemoryStore()
+	source.fetchFailures.Store(3)
+	source.commitFailures.Store(3)
+	stop := startConsumer(t, source, store)
+
+	source.Feed([]byte("k"), []byte(`{"feedId": "feed1", "actionTextTemplate": "{subject} waved."}`))
+	source.Feed([]byte("k"), []byte(`{"feedId": "feed2", "actionTextTemplate": "{subject} waved."}`))
+	waitCommitted(t, source.memorySource, 1)
+	stop()
+
+	for _, feedId := range []string{"feed1", "feed2"} {
+		if _, err := store.GetActivity(context.Background(), feedId); err != nil {
+			t.Fatalf("%s: %v", feedId, err)
+		}
+	}
+}
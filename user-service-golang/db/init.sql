@@ -1,17 +1,34 @@
+-- Schema for fresh docker-entrypoint-initdb volumes. It is the migrations in
+-- db/migrations applied in order, and they are written to apply cleanly on
+-- top of it, so "migrate up" only has to record them. Keep the two in step.
+
 -- Create users table
 CREATE TABLE IF NOT EXISTS users (
     id VARCHAR(255) PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
-    last_seen TIMESTAMP NOT NULL
+    last_seen TIMESTAMP NOT NULL,
+    -- Who may read the user's activities: anyone, or only the user and
+    -- services allowed to read everyone's.
+    activity_visibility VARCHAR(16) NOT NULL DEFAULT 'PUBLIC'
+        CHECK (activity_visibility IN ('PUBLIC', 'PRIVATE'))
 );
 
+-- User names are unique regardless of case
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_name_lower ON users(lower(name));
+
+-- Online users are found by how recently they were last seen
+CREATE INDEX IF NOT EXISTS idx_users_last_seen ON users(last_seen);
+
 -- Create user_activities table
 CREATE TABLE IF NOT EXISTS user_activities (
     feed_id VARCHAR(255) PRIMARY KEY,
     action_text_template TEXT NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Activities are paginated newest first by (created_at, feed_id)
+CREATE INDEX IF NOT EXISTS idx_user_activities_created_at_feed_id ON user_activities(created_at, feed_id);
+
 -- Create user_activity_subject_referring table (many-to-many relationship)
 CREATE TABLE IF NOT EXISTS user_activity_subject_referring (
     feed_id VARCHAR(255) NOT NULL,
@@ -37,3 +54,48 @@ CREATE INDEX IF NOT EXISTS idx_user_activity_subject_referring_feed_id ON user_a
 CREATE INDEX IF NOT EXISTS idx_user_activity_object_referring_feed_id ON user_activity_object_referring(feed_id);
 CREATE INDEX IF NOT EXISTS idx_user_activity_subject_referring_id ON user_activity_subject_referring(referring_id);
 CREATE INDEX IF NOT EXISTS idx_user_activity_object_referring_id ON user_activity_object_referring(referring_id);
+CREATE INDEX IF NOT EXISTS idx_user_activity_subject_referring_user_id ON user_activity_subject_referring(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_activity_object_referring_user_id ON user_activity_object_referring(user_id);
+
+-- Every change to an activity, including its referrings, records when it
+-- happened and notifies the user_activity_changes channel with its feed_id and
+-- that time, as {"feed_id": ..., "changed_at": ...}, so that each instance can
+-- pick it up. changed_at is the start of the transaction, and Postgres folds
+-- identical notifications within a transaction, so an upsert notifies once.
+CREATE TABLE IF NOT EXISTS user_activity_changes (
+    feed_id VARCHAR(255) PRIMARY KEY,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_activity_changes_changed_at ON user_activity_changes(changed_at);
+
+CREATE OR REPLACE FUNCTION notify_user_activity_change() RETURNS TRIGGER AS $$
+DECLARE
+    changed_feed_id VARCHAR(255);
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_feed_id := OLD.feed_id;
+    ELSE
+        changed_feed_id := NEW.feed_id;
+    END IF;
+
+    INSERT INTO user_activity_changes (feed_id, changed_at)
+    VALUES (changed_feed_id, now())
+    ON CONFLICT (feed_id) DO UPDATE SET changed_at = EXCLUDED.changed_at;
+    PERFORM pg_notify('user_activity_changes',
+        json_build_object('feed_id', changed_feed_id, 'changed_at', now())::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER user_activities_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON user_activities
+    FOR EACH ROW EXECUTE FUNCTION notify_user_activity_change();
+
+CREATE OR REPLACE TRIGGER user_activity_subject_referring_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON user_activity_subject_referring
+    FOR EACH ROW EXECUTE FUNCTION notify_user_activity_change();
+
+CREATE OR REPLACE TRIGGER user_activity_object_referring_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON user_activity_object_referring
+    FOR EACH ROW EXECUTE FUNCTION notify_user_activity_change();
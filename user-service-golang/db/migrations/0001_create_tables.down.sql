@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS user_activity_object_referring;
+DROP TABLE IF EXISTS user_activity_subject_referring;
+DROP TABLE IF EXISTS user_activities;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,2 @@
+-- User names are unique regardless of case
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_name_lower ON users(lower(name));
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS user_activity_object_referring_notify_change ON user_activity_object_referring;
+DROP TRIGGER IF EXISTS user_activity_subject_referring_notify_change ON user_activity_subject_referring;
+DROP TRIGGER IF EXISTS user_activities_notify_change ON user_activities;
+DROP FUNCTION IF EXISTS notify_user_activity_change();
+DROP TABLE IF EXISTS user_activity_changes;
@@ -0,0 +1,40 @@
+-- Every change to an activity, including its referrings, records when it
+-- happened and notifies the user_activity_changes channel with its feed_id so
+-- that each instance can pick it up. Postgres folds identical notifications
+-- within a transaction, so an upsert notifies once.
+CREATE TABLE IF NOT EXISTS user_activity_changes (
+    feed_id VARCHAR(255) PRIMARY KEY,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_activity_changes_changed_at ON user_activity_changes(changed_at);
+
+CREATE OR REPLACE FUNCTION notify_user_activity_change() RETURNS TRIGGER AS $$
+DECLARE
+    changed_feed_id VARCHAR(255);
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_feed_id := OLD.feed_id;
+    ELSE
+        changed_feed_id := NEW.feed_id;
+    END IF;
+
+    INSERT INTO user_activity_changes (feed_id, changed_at)
+    VALUES (changed_feed_id, clock_timestamp())
+    ON CONFLICT (feed_id) DO UPDATE SET changed_at = EXCLUDED.changed_at;
+    PERFORM pg_notify('user_activity_changes', changed_feed_id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER user_activities_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON user_activities
+    FOR EACH ROW EXECUTE FUNCTION notify_user_activity_change();
+
+CREATE OR REPLACE TRIGGER user_activity_subject_referring_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON user_activity_subject_referring
+    FOR EACH ROW EXECUTE FUNCTION notify_user_activity_change();
+
+CREATE OR REPLACE TRIGGER user_activity_object_referring_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON user_activity_object_referring
+    FOR EACH ROW EXECUTE FUNCTION notify_user_activity_change();
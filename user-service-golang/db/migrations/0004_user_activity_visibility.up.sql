@@ -0,0 +1,4 @@
+-- Who may read a user's activities: anyone, or only the user and services
+-- allowed to read everyone's.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activity_visibility VARCHAR(16) NOT NULL DEFAULT 'PUBLIC'
+    CHECK (activity_visibility IN ('PUBLIC', 'PRIVATE'));
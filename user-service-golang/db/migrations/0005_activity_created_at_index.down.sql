@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_user_activities_created_at_feed_id;
+ALTER TABLE user_activities ALTER COLUMN created_at DROP NOT NULL;
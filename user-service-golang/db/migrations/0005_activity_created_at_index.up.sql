@@ -0,0 +1,4 @@
+-- Activities are paginated newest first by (created_at, feed_id).
+UPDATE user_activities SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+ALTER TABLE user_activities ALTER COLUMN created_at SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_user_activities_created_at_feed_id ON user_activities(created_at, feed_id);
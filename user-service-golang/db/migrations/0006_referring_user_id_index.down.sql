@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_user_activity_object_referring_user_id;
+DROP INDEX IF EXISTS idx_user_activity_subject_referring_user_id;
@@ -0,0 +1,3 @@
+-- Activities are found by the users that own their referrings.
+CREATE INDEX IF NOT EXISTS idx_user_activity_subject_referring_user_id ON user_activity_subject_referring(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_activity_object_referring_user_id ON user_activity_object_referring(user_id);
@@ -0,0 +1,2 @@
+-- Online users are found by how recently they were last seen.
+CREATE INDEX IF NOT EXISTS idx_users_last_seen ON users(last_seen);
@@ -0,0 +1,17 @@
+CREATE OR REPLACE FUNCTION notify_user_activity_change() RETURNS TRIGGER AS $$
+DECLARE
+    changed_feed_id VARCHAR(255);
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_feed_id := OLD.feed_id;
+    ELSE
+        changed_feed_id := NEW.feed_id;
+    END IF;
+
+    INSERT INTO user_activity_changes (feed_id, changed_at)
+    VALUES (changed_feed_id, clock_timestamp())
+    ON CONFLICT (feed_id) DO UPDATE SET changed_at = EXCLUDED.changed_at;
+    PERFORM pg_notify('user_activity_changes', changed_feed_id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,23 @@
+-- Notifications carry when the change happened along with the feed_id, as
+-- {"feed_id": ..., "changed_at": ...}, so listeners know how far they have
+-- caught up without reading user_activity_changes back. changed_at is the
+-- start of the transaction, the same for every row it touches, so an upsert
+-- still notifies once.
+CREATE OR REPLACE FUNCTION notify_user_activity_change() RETURNS TRIGGER AS $$
+DECLARE
+    changed_feed_id VARCHAR(255);
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_feed_id := OLD.feed_id;
+    ELSE
+        changed_feed_id := NEW.feed_id;
+    END IF;
+
+    INSERT INTO user_activity_changes (feed_id, changed_at)
+    VALUES (changed_feed_id, now())
+    ON CONFLICT (feed_id) DO UPDATE SET changed_at = EXCLUDED.changed_at;
+    PERFORM pg_notify('user_activity_changes',
+        json_build_object('feed_id', changed_feed_id, 'changed_at', now())::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
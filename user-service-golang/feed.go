@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// feedViewer is the point of view activities are rendered from. A nil you
+// gives the neutral third-person rendering. The users that name referrings
+// are loaded by load before rendering and kept for the life of the viewer,
+// which streams share between their goroutines.
+type feedViewer struct {
+	you    *proto.User
+	loc    *localizer
+	lookup UserStore
+
+	mu sync.Mutex
+	// users holds the users loaded so far, and nil for ids that turned
+	// out not to exist.
+	users map[string]*proto.User
+}
+
+// newFeedViewer loads the viewer. viewerId may be empty for a third-person
+// rendering; otherwise it must name a known user.
+func newFeedViewer(ctx context.Context, users UserStore, viewerId string, loc *localizer) (*feedViewer, error) {
+	v := &feedViewer{loc: loc, lookup: users, users: make(map[string]*proto.User)}
+	if viewerId != "" {
+		you, err := users.GetUser(ctx, viewerId)
+		if err != nil {
+			return nil, err
+		}
+		v.you = you
+		v.users[you.Id] = you
+	}
+	return v, nil
+}
+
+// load loads the users owning the referrings of activities that the viewer
+// hasn't looked up yet.
+func (v *feedViewer) load(ctx context.Context, activities ...*proto.UserActivity) error {
+	v.mu.Lock()
+	var ids []string
+	for _, activity := range activities {
+		for _, id := range activityOwnerIds(activity) {
+			if _, ok := v.users[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	v.mu.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	users, err := v.lookup.GetUsers(ctx, ids)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, id := range ids {
+		v.users[id] = nil
+	}
+	for _, user := range users {
+		v.users[user.Id] = user
+	}
+	return nil
+}
+
+// referringUserId is the user a referring belongs to: the user itself for
+// USER referrings, the owner for anything else.
+func referringUserId(referring *proto.UserActivityReferring) string {
+	if referring.UserId != "" {
+		return referring.UserId
+	}
+	if referring.Type == proto.ReferringType_USER {
+		return referring.Id
+	}
+	return ""
+}
+
+func (v *feedViewer) isYou(referring *proto.UserActivityReferring) bool {
+	return v.you != nil && referringUserId(referring) == v.you.Id
+}
+
+func (v *feedViewer) userName(referring *proto.UserActivityReferring) string {
+	if v.isYou(referring) {
+		return v.loc.message("you", "", 1)
+	}
+	userId := referringUserId(referring)
+	v.mu.Lock()
+	user := v.users[userId]
+	v.mu.Unlock()
+	if user != nil {
+		return user.Name
+	}
+	if userId != "" {
+		return userId
+	}
+	return referring.Id
+}
+
+// referringsText names a list of referrings, headlining the viewer when they
+// are among them: "you", "Bob", "you and 2 others". onlyYou reports whether
+// the text is just the viewer.
+func (v *feedViewer) referringsText(referrings []*proto.UserActivityReferring, fallback string) (text string, onlyYou bool) {
+	if len(referrings) == 0 {
+		return v.loc.message(fallback, "", 1), false
+	}
+	headline := referrings[len(referrings)-1]
+	for _, referring := range referrings {
+		if v.isYou(referring) {
+			headline = referring
+			break
+		}
+	}
+	text = v.userName(headline)
+	if len(referrings) > 1 {
+		return v.loc.message("andOthers", text, len(referrings)-1), false
+	}
+	return text, v.isYou(headline)
+}
+
+func (v *feedViewer) phrase(referrings []*proto.UserActivityReferring, fallback string) Phrase {
+	text, onlyYou := v.referringsText(referrings, fallback)
+	phrase := Phrase{Text: text, Count: len(referrings)}
+	if onlyYou {
+		phrase.Possessive = v.loc.message("yourPossessive", "", 1)
+	} else {
+		phrase.Possessive = v.loc.possessive(text)
+	}
+	return phrase
+}
+
+func (v *feedViewer) subjectPhrase(referrings []*proto.UserActivityReferring) Phrase {
+	return v.phrase(referrings, "someone")
+}
+
+// objectPhrase is like subjectPhrase, except that non-user objects such as
+// posts are always named by their owners in the possessive, since templates
+// read "{subject} commented on {object} post."
+func (v *feedViewer) objectPhrase(referrings []*proto.UserActivityReferring) Phrase {
+	phrase := v.phrase(referrings, "something")
+	if len(referrings) > 0 && referrings[0].Type != proto.ReferringType_USER {
+		phrase.Text = phrase.Possessive
+	}
+	return phrase
+}
+
+// renderActionText fills in the localised variant of the activity's template
+// for the viewer. A template that doesn't parse is shown as is.
+func (v *feedViewer) renderActionText(activity *proto.UserActivity) string {
+	t, err := ParseTemplate(v.loc.template(activity.ActionTextTemplate))
+	if err != nil {
+		return activity.ActionTextTemplate
+	}
+	text := t.Execute(TemplateData{
+		Subject: v.subjectPhrase(activity.SubjectReferring),
+		Object:  v.objectPhrase(activity.ObjectReferring),
+		Count:   len(activity.SubjectReferring),
+		Time:    activityKey(activity).CreatedAt,
+	})
+	if v.loc.capitalize() {
+		text = capitalize(text)
+	}
+	return text
+}
+
+func capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+func protoActivityToJSON(activity *proto.UserActivity, v *feedViewer) map[string]interface{} {
+	subjectReferring := make([]map[string]interface{}, len(activity.SubjectReferring))
+	for i, p := range activity.SubjectReferring {
+		subjectReferring[i] = map[string]interface{}{
+			"type": p.Type.String(),
+			"id":   p.Id,
+		}
+	}
+
+	objectReferring := make([]map[string]interface{}, len(activity.ObjectReferring))
+	for i, p := range activity.ObjectReferring {
+		objectReferring[i] = map[string]interface{}{
+			"type": p.Type.String(),
+			"id":   p.Id,
+		}
+	}
+
+	response := map[string]interface{}{
+		"feedId":             activity.FeedId,
+		"subjectReferring":   subjectReferring,
+		"objectReferring":    objectReferring,
+		"actionTextTemplate": activity.ActionTextTemplate,
+		"actionText":         v.renderActionText(activity),
+	}
+	if activity.CreatedAt != "" {
+		response["createdAt"] = activity.CreatedAt
+	}
+	if len(activity.FeedIds) > 0 {
+		response["feedIds"] = activity.FeedIds
+	}
+	return response
+}
+
+// involvesUser reports whether userId is a subject of the activity or owns
+// one of its objects.
+func involvesUser(activity *proto.UserActivity, userId string) bool {
+	for _, referring := range activity.SubjectReferring {
+		if referringUserId(referring) == userId {
+			return true
+		}
+	}
+	for _, referring := range activity.ObjectReferring {
+		if referringUserId(referring) == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// localizer picks the locale for the request from Accept-Language and
+// reports it back in Content-Language.
+func (s *server) localizer(c *gin.Context) *localizer {
+	loc := s.locales.localizer(c.GetHeader("Accept-Language"))
+	c.Header("Content-Language", loc.tag.String())
+	return loc
+}
+
+// viewerFromQuery resolves the optional ?viewer= parameter, which defaults
+// to the caller. On failure it has already written the response.
+func (s *server) viewerFromQuery(c *gin.Context) (*feedViewer, bool) {
+	viewerId := c.Query("viewer")
+	if caller := callerFromContext(c); caller != nil && (viewerId == "" || viewerId == meId) {
+		viewerId = caller.Id
+	} else if viewerId == meId {
+		unauthorized(c, "", "authentication is required for viewer="+meId)
+		return nil, false
+	}
+	v, err := newFeedViewer(c.Request.Context(), s.users, viewerId, s.localizer(c))
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "viewer not found"})
+		return nil, false
+	}
+	if err != nil {
+		internalError(c, err)
+		return nil, false
+	}
+	return v, true
+}
+
+// getUserFeed lists a page of the activities involving a user, newest
+// first, rendered for that user.
+func (s *server) getUserFeed(c *gin.Context) {
+	ctx := c.Request.Context()
+	list := userFeedList(c.Param("id"))
+	q, ok := s.pageQueryFromRequest(c, list)
+	if !ok {
+		return
+	}
+	collapse, ok := collapseFromRequest(c)
+	if !ok {
+		return
+	}
+	v, err := newFeedViewer(ctx, s.users, c.Param("id"), s.localizer(c))
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if !s.authorize(c, policyReadUserActivities, accessRequest{owner: v.you}) {
+		return
+	}
+
+	f := activityFilter{Id: v.you.Id, ByUser: true}
+	s.restrictToReadable(&f, callerFromContext(c), claimsFromContext(c))
+	activities, more, err := s.listActivitiesPage(ctx, f, q, collapse)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	response := &proto.ListActivitiesResponse{Activities: activities}
+	response.NextCursor, response.PrevCursor = pageCursors(s.pages, list, q, activities, activityKey, more)
+	setPageLinks(c, response.NextCursor, response.PrevCursor)
+	respondActivities(c, response, v)
+}
+
+// userFeedList names the list of a user's feed in its cursors.
+func userFeedList(userId string) string {
+	return "users/" + userId + "/feed"
+}
+
+// respondActivities writes a list of activities, rendering them for the
+// viewer in the legacy JSON shape, which is a bare array without the
+// cursors.
+func respondActivities(c *gin.Context, response *proto.ListActivitiesResponse, v *feedViewer) {
+	respond(c, http.StatusOK, response, func() (any, error) {
+		if err := v.load(c.Request.Context(), response.Activities...); err != nil {
+			return nil, err
+		}
+		c.Set(renderedActivitiesKey, len(response.Activities))
+		activities := make([]map[string]interface{}, len(response.Activities))
+		for i, activity := range response.Activities {
+			activities[i] = protoActivityToJSON(activity, v)
+		}
+		return activities, nil
+	})
+}
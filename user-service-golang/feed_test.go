@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"charles/career-break-learn/user-service-golang/proto"
+)
+
+// recordingUserStore records the ids users are looked up by and fails
+// listing them all.
+type recordingUserStore struct {
+	*memoryStore
+	lookups [][]string
+}
+
+func (s *recordingUserStore) ListUsers(ctx context.Context) ([]*proto.User, error) {
+	panic("ListUsers called")
+}
+
+func (s *recordingUserStore) GetUsers(ctx context.Context, ids []string) ([]*proto.User, error) {
+	s.lookups = append(s.lookups, ids)
+	return s.memoryStore.GetUsers(ctx, ids)
+}
+
+func TestFeedViewerLoadsNamedUsers(t *testing.T) {
+	locales, err := loadLocaleBundle("locales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := &recordingUserStore{memoryStore: newSeededMemoryStore()}
+	v, err := newFeedViewer(context.Background(), users, "1", locales.localizer("en"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	like := &proto.UserActivity{
+		FeedId:             "like1",
+		ActionTextTemplate: "{subject} liked {object} post.",
+		SubjectReferring:   []*proto.UserActivityReferring{{Type: proto.ReferringType_USER, Id: "3"}},
+		ObjectReferring:    []*proto.UserActivityReferring{{Type: proto.ReferringType_POST, Id: "77", UserId: "2"}},
+	}
+	wave := &proto.UserActivity{
+		FeedId:             "wave1",
+		ActionTextTemplate: "{subject} waved.",
+		SubjectReferring:   []*proto.UserActivityReferring{{Type: proto.ReferringType_USER, Id: "1"}, {Type: proto.ReferringType_USER, Id: "404"}},
+	}
+	for _, activities := range [][]*proto.UserActivity{{like}, {like, wave}, {wave}} {
+		if err := v.load(context.Background(), activities...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The viewer is known from the start, and users that don't exist are
+	// remembered not to.
+	if !slices.EqualFunc(users.lookups, [][]string{{"2", "3"}, {"404"}}, slices.Equal) {
+		t.Fatalf("looked up %v", users.lookups)
+	}
+	if got := protoActivityToJSON(like, v)["actionText"]; got != "Charlie liked Bob's post." {
+		t.Fatalf("actionText = %q", got)
+	}
+}
+
+func TestRenderActionTextTime(t *testing.T) {
+	locales, err := loadLocaleBundle("locales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := newFeedViewer(context.Background(), newSeededMemoryStore(), "", locales.localizer("en"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	activity := &proto.UserActivity{
+		FeedId:             "wave1",
+		ActionTextTemplate: "{subject} waved at {time}.",
+		SubjectReferring:   []*proto.UserActivityReferring{{Type: proto.ReferringType_USER, Id: "2"}},
+		CreatedAt:          "2024-06-01T12:00:00.5Z",
+	}
+	if err := v.load(context.Background(), activity); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.renderActionText(activity); got != "Bob waved at 2024-06-01T12:00:00Z." {
+		t.Fatalf("actionText = %q", got)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// activityRole is where an activity filter looks for referrings.
+type activityRole int
+
+const (
+	roleAny activityRole = iota
+	roleSubject
+	roleObject
+)
+
+// activityFilter narrows down a list of activities. The zero filter matches
+// them all, and every field set has to match.
+type activityFilter struct {
+	// Id is matched against the referrings in Role: against their referring
+	// ids or, with ByUser, against the users that own them.
+	Id     string
+	ByUser bool
+	Role   activityRole
+	// ReferringType, if set, keeps only referrings of that type. Without Id
+	// it matches activities with any referring of that type in Role.
+	ReferringType *proto.ReferringType
+	// Since and Until bound created_at, Until exclusively. Zero is unbounded.
+	Since time.Time
+	Until time.Time
+	// Template matches action_text_template exactly.
+	Template string
+	// HidePrivate leaves out activities involving users who made their
+	// activities private, other than Reader.
+	HidePrivate bool
+	Reader      string
+}
+
+// byReferring reports whether the filter looks at referrings at all.
+func (f activityFilter) byReferring() bool {
+	return f.Id != "" || f.ReferringType != nil
+}
+
+// matches reports whether f matches activity, for stores that filter in Go.
+// HidePrivate is left to them, as it takes the users involved.
+func (f activityFilter) matches(activity *proto.UserActivity) bool {
+	if f.byReferring() {
+		found := f.Role != roleObject && f.matchesAny(activity.SubjectReferring)
+		if !found && f.Role != roleSubject {
+			found = f.matchesAny(activity.ObjectReferring)
+		}
+		if !found {
+			return false
+		}
+	}
+	if createdAt := activityKey(activity).CreatedAt; !f.Since.IsZero() && createdAt.Before(f.Since) ||
+		!f.Until.IsZero() && !createdAt.Before(f.Until) {
+		return false
+	}
+	return f.Template == "" || activity.ActionTextTemplate == f.Template
+}
+
+func (f activityFilter) matchesAny(referrings []*proto.UserActivityReferring) bool {
+	for _, referring := range referrings {
+		if f.ReferringType != nil && referring.Type != *f.ReferringType {
+			continue
+		}
+		switch {
+		case f.Id == "":
+			return true
+		case f.ByUser && referringUserId(referring) == f.Id:
+			return true
+		case !f.ByUser && referring.Id == f.Id:
+			return true
+		}
+	}
+	return false
+}
+
+// parseActivityFilter reads the filter parameters shared by the REST and gRPC
+// list endpoints, as proto.ActivityFilter describes them. Id is left for the
+// caller to set.
+func parseActivityFilter(params *proto.ActivityFilter) (activityFilter, error) {
+	f := activityFilter{ByUser: true, Template: params.GetActionTextTemplate()}
+	switch params.GetAs() {
+	case "", "any":
+	case "subject":
+		f.Role = roleSubject
+	case "object":
+		f.Role = roleObject
+	default:
+		return activityFilter{}, errors.New("as must be subject, object or any")
+	}
+	switch params.GetMatch() {
+	case "", "user":
+	case "referring":
+		f.ByUser = false
+	default:
+		return activityFilter{}, errors.New("match must be user or referring")
+	}
+	if referringType := params.GetReferringType(); referringType != "" {
+		value, ok := proto.ReferringType_value[strings.ToUpper(referringType)]
+		if !ok {
+			return activityFilter{}, fmt.Errorf("unknown referring type %q", referringType)
+		}
+		t := proto.ReferringType(value)
+		f.ReferringType = &t
+	}
+
+	var err error
+	if f.Since, err = parseFilterTime("since", params.GetSince()); err != nil {
+		return activityFilter{}, err
+	}
+	if f.Until, err = parseFilterTime("until", params.GetUntil()); err != nil {
+		return activityFilter{}, err
+	}
+	if !f.Since.IsZero() && !f.Until.IsZero() && !f.Since.Before(f.Until) {
+		return activityFilter{}, errors.New("since must be before until")
+	}
+	return f, nil
+}
+
+func parseFilterTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+	}
+	return t.UTC(), nil
+}
+
+// activityFilterFromRequest reads ?as=, ?match=, ?referringType=, ?since=,
+// ?until= and ?template=. On failure it has already written the response.
+func activityFilterFromRequest(c *gin.Context) (activityFilter, bool) {
+	f, err := parseActivityFilter(&proto.ActivityFilter{
+		As:                 c.Query("as"),
+		Match:              c.Query("match"),
+		ReferringType:      c.Query("referringType"),
+		Since:              c.Query("since"),
+		Until:              c.Query("until"),
+		ActionTextTemplate: c.Query("template"),
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return activityFilter{}, false
+	}
+	return f, true
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// grpcUserService serves proto.UserService from the same stores as the REST
+// handlers.
+type grpcUserService struct {
+	proto.UnimplementedUserServiceServer
+	s *server
+}
+
+func (g *grpcUserService) GetUser(ctx context.Context, req *proto.GetUserRequest) (*proto.User, error) {
+	user, err := g.s.users.GetUser(ctx, req.Id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.s.presence.apply(user, time.Now())
+	return user, nil
+}
+
+func (g *grpcUserService) ListUsers(ctx context.Context, req *proto.ListUsersRequest) (*proto.ListUsersResponse, error) {
+	q, err := g.pageQuery("users", req.Cursor, req.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	users, more, err := g.s.users.ListUsersPage(ctx, q)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.s.presence.applyAll(users)
+	response := &proto.ListUsersResponse{Users: users}
+	response.NextCursor, response.PrevCursor = pageCursors(g.s.pages, "users", q, users, userKey, more)
+	return response, nil
+}
+
+func (g *grpcUserService) ListActivities(ctx context.Context, req *proto.ListActivitiesRequest) (*proto.ListActivitiesResponse, error) {
+	q, err := g.pageQuery("activities", req.Cursor, req.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseActivityFilter(req.Filter)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	caller, claims := grpcAuthFromContext(ctx)
+	g.s.restrictToReadable(&f, caller, claims)
+	activities, more, err := g.s.listActivitiesPage(ctx, f, q, req.Collapse)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	response := &proto.ListActivitiesResponse{Activities: activities}
+	response.NextCursor, response.PrevCursor = pageCursors(g.s.pages, "activities", q, activities, activityKey, more)
+	return response, nil
+}
+
+// ListUserActivities takes "-" as user_id for the caller, as the REST API
+// does.
+func (g *grpcUserService) ListUserActivities(ctx context.Context, req *proto.ListUserActivitiesRequest) (*proto.ListActivitiesResponse, error) {
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	userId := req.UserId
+	if userId == meId {
+		caller, _ := grpcAuthFromContext(ctx)
+		if caller == nil {
+			return nil, status.Error(codes.Unauthenticated, "authentication is required for "+meId)
+		}
+		userId = caller.Id
+	}
+	owner, err := g.s.activitiesOwner(ctx, userId)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := g.authorize(ctx, policyReadUserActivities, accessRequest{owner: owner}); err != nil {
+		return nil, err
+	}
+
+	list := userActivitiesList(userId)
+	q, err := g.pageQuery(list, req.Cursor, req.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseActivityFilter(req.Filter)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	caller, claims := grpcAuthFromContext(ctx)
+	g.s.restrictToReadable(&f, caller, claims)
+	f.Id = userId
+	activities, more, err := g.s.listActivitiesPage(ctx, f, q, req.Collapse)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	response := &proto.ListActivitiesResponse{Activities: activities}
+	response.NextCursor, response.PrevCursor = pageCursors(g.s.pages, list, q, activities, activityKey, more)
+	return response, nil
+}
+
+// pageQuery reads the page a list request asks for. Cursors are the same as
+// the REST API's, so a client can carry them from one to the other.
+func (g *grpcUserService) pageQuery(list, cursor string, size int32) (pageQuery, error) {
+	if size < 0 {
+		return pageQuery{}, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	}
+	q, err := g.s.pages.query(list, cursor, int(size))
+	if err != nil {
+		return pageQuery{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return q, nil
+}
+
+func (g *grpcUserService) CreateActivity(ctx context.Context, req *proto.CreateActivityRequest) (*proto.UserActivity, error) {
+	if req.Activity == nil {
+		return nil, status.Error(codes.InvalidArgument, "activity is required")
+	}
+	if err := validateActivity(req.Activity); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := g.authorize(ctx, policyCreateActivity, accessRequest{activity: req.Activity}); err != nil {
+		return nil, err
+	}
+	activity, err := g.s.createActivity(ctx, req.Activity)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return activity, nil
+}
+
+// authorize is server.authorize for gRPC calls: it decides r for the caller
+// and fails with Unauthenticated for anonymous callers, who might be allowed
+// once signed in, and PermissionDenied for everyone else.
+func (g *grpcUserService) authorize(ctx context.Context, p policy, r accessRequest) error {
+	r.caller, r.claims = grpcAuthFromContext(ctx)
+	if g.s.allow(ctx, p, r) {
+		return nil
+	}
+	if r.claims == nil {
+		return status.Error(codes.Unauthenticated, "authentication is required: "+p.denial)
+	}
+	return status.Error(codes.PermissionDenied, p.denial)
+}
+
+// grpcAuthenticate authenticates the bearer token in the authorization
+// metadata of a call, if there is one, for the methods to authorize as the
+// REST handlers do. Calls without one go through as anonymous. When
+// authentication is off every call does, and is allowed only if the server
+// is insecure.
+func (s *server) grpcAuthenticate(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.auth == nil {
+		return handler(ctx, req)
+	}
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token = parseBearer(values[0])
+		}
+	}
+	if token == "" {
+		return handler(ctx, req)
+	}
+	user, claims, err := s.auth.Authenticate(ctx, token)
+	if err != nil && isTokenError(err) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return handler(context.WithValue(ctx, grpcAuthKey{}, grpcAuth{caller: user, claims: claims}), req)
+}
+
+func grpcError(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// newGRPCServer registers UserService alongside the standard health service
+// and server reflection, so grpcurl can list and call it.
+func (s *server) newGRPCServer() *grpc.Server {
+	g := grpc.NewServer(grpc.UnaryInterceptor(s.grpcAuthenticate))
+	proto.RegisterUserServiceServer(g, &grpcUserService{s: s})
+
+	s.grpcHealth = health.NewServer()
+	s.grpcHealth.SetServingStatus(proto.UserService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(g, s.grpcHealth)
+
+	reflection.Register(g)
+	return g
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcClient serves ts over gRPC in memory and returns a client of it.
+func grpcClient(t *testing.T, ts *testServer) proto.UserServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	g := ts.newGRPCServer()
+	go g.Serve(listener)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewUserServiceClient(conn)
+}
+
+// withToken returns a context that sends token as the bearer token, unless
+// it is empty.
+func withToken(token string) context.Context {
+	if token == "" {
+		return context.Background()
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("err = %v, want %s", err, code)
+	}
+}
+
+func TestGRPCCreateActivity(t *testing.T) {
+	ts := newTestServer(t)
+	client := grpcClient(t, ts)
+	req := &proto.CreateActivityRequest{Activity: &proto.UserActivity{
+		FeedId:             "like1",
+		ActionTextTemplate: "{subject} liked {object} post.",
+		SubjectReferring:   []*proto.UserActivityReferring{{Type: proto.ReferringType_USER, Id: "1"}},
+		ObjectReferring:    []*proto.UserActivityReferring{{Type: proto.ReferringType_POST, Id: "77", UserId: "2"}},
+	}}
+
+	_, err := client.CreateActivity(withToken(""), req)
+	expectCode(t, err, codes.Unauthenticated)
+	_, err = client.CreateActivity(withToken("not a token"), req)
+	expectCode(t, err, codes.Unauthenticated)
+	_, err = client.CreateActivity(withToken(token(t, "2", "")), req)
+	expectCode(t, err, codes.PermissionDenied)
+	if _, err := ts.store.GetActivity(context.Background(), "like1"); err == nil {
+		t.Fatal("a denied activity was stored")
+	}
+
+	if _, err := client.CreateActivity(withToken(token(t, "1", "")), req); err != nil {
+		t.Fatal(err)
+	}
+	req.Activity.FeedId = "like2"
+	if _, err := client.CreateActivity(withToken(token(t, "processor", scopeActivitiesWriteAny)), req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGRPCPrivateUserActivities(t *testing.T) {
+	ts := newTestServer(t)
+	client := grpcClient(t, ts)
+	alice := token(t, "1", "")
+	expectStatus(t, ts.do(t, http.MethodPatch, "/users/1", alice, map[string]any{"activityVisibility": "PRIVATE"}), http.StatusOK)
+
+	_, err := client.ListUserActivities(withToken(""), &proto.ListUserActivitiesRequest{UserId: "1"})
+	expectCode(t, err, codes.Unauthenticated)
+	_, err = client.ListUserActivities(withToken(token(t, "2", "")), &proto.ListUserActivitiesRequest{UserId: "1"})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = client.ListUserActivities(withToken(""), &proto.ListUserActivitiesRequest{UserId: meId})
+	expectCode(t, err, codes.Unauthenticated)
+
+	response, err := client.ListUserActivities(withToken(alice), &proto.ListUserActivitiesRequest{UserId: meId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Activities) != 1 || response.Activities[0].FeedId != "feed1" {
+		t.Fatalf("activities = %v", response.Activities)
+	}
+	if _, err := client.ListUserActivities(withToken(token(t, "auditor", scopeActivitiesReadAny)), &proto.ListUserActivitiesRequest{UserId: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	// Public activities need no token.
+	if _, err := client.ListUserActivities(withToken(""), &proto.ListUserActivitiesRequest{UserId: "2"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGRPCPrivateActivitiesLeftOutOfLists(t *testing.T) {
+	ts := newTestServer(t)
+	client := grpcClient(t, ts)
+	expectStatus(t, ts.do(t, http.MethodPatch, "/users/1", token(t, "1", ""), map[string]any{"activityVisibility": "PRIVATE"}), http.StatusOK)
+
+	all, err := client.ListActivities(withToken(""), &proto.ListActivitiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobs, err := client.ListUserActivities(withToken(token(t, "3", "")), &proto.ListUserActivitiesRequest{UserId: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Activities) != 0 || len(bobs.Activities) != 0 {
+		t.Fatalf("Alice's private activity was listed: %v, %v", all.Activities, bobs.Activities)
+	}
+	all, err = client.ListActivities(withToken(token(t, "1", "")), &proto.ListActivitiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Activities) != 1 {
+		t.Fatalf("Alice doesn't see her own activity: %v", all.Activities)
+	}
+}
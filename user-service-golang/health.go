@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthChecker backs /readyz: the service is ready when it isn't draining
+// and every registered dependency check passes.
+type healthChecker struct {
+	timeout  time.Duration
+	draining atomic.Bool
+
+	mu     sync.Mutex
+	checks map[string]func(context.Context) error
+}
+
+func newHealthChecker(timeout time.Duration) *healthChecker {
+	return &healthChecker{timeout: timeout, checks: make(map[string]func(context.Context) error)}
+}
+
+// Register adds a dependency check, such as a database ping.
+func (h *healthChecker) Register(name string, check func(context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// Drain marks the service as shutting down, so it stops being ready and load
+// balancers send no more traffic its way.
+func (h *healthChecker) Drain() {
+	h.draining.Store(true)
+}
+
+// Check runs every check concurrently and returns the failures by name.
+func (h *healthChecker) Check(ctx context.Context) map[string]string {
+	h.mu.Lock()
+	checks := make(map[string]func(context.Context) error, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	results := make(map[string]string, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := "ok"
+			if err := check(ctx); err != nil {
+				result = err.Error()
+			}
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// getHealthz is the liveness probe: the process is up and serving requests.
+func (s *server) getHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// getReadyz is the readiness probe. It answers 503 while draining or while
+// any dependency check fails, listing each check's result.
+func (s *server) getReadyz(c *gin.Context) {
+	results := s.health.Check(c.Request.Context())
+	ready := !s.health.draining.Load()
+	for _, result := range results {
+		if result != "ok" {
+			ready = false
+		}
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	if s.health.draining.Load() {
+		status = "draining"
+	}
+	c.JSON(code, gin.H{"status": status, "checks": results})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+)
+
+//go:embed locales/*.json
+var builtinLocales embed.FS
+
+var defaultLocale = language.English
+
+// localeCatalog is one locales/<tag>.json file: the phrases used to name
+// subjects and objects, and localised variants of action text templates
+// keyed by the template stored with the activity.
+type localeCatalog struct {
+	Locale     string                   `json:"locale"`
+	Fallback   string                   `json:"fallback"`
+	Capitalize bool                     `json:"capitalize"`
+	Messages   map[string]localeMessage `json:"messages"`
+	Templates  map[string]string        `json:"templates"`
+
+	tag language.Tag
+}
+
+// localeMessage holds a message's CLDR plural forms ("zero", "one", "two",
+// "few", "many", "other"). A plain string in the catalog is its "other" form.
+type localeMessage map[string]string
+
+func (m *localeMessage) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*m = localeMessage{"other": s}
+		return nil
+	}
+	var forms map[string]string
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return err
+	}
+	if _, ok := forms["other"]; !ok {
+		return fmt.Errorf("plural message has no \"other\" form")
+	}
+	*m = forms
+	return nil
+}
+
+var pluralFormNames = map[plural.Form]string{
+	plural.Zero:  "zero",
+	plural.One:   "one",
+	plural.Two:   "two",
+	plural.Few:   "few",
+	plural.Many:  "many",
+	plural.Other: "other",
+}
+
+// localeBundle is the set of available catalogs.
+type localeBundle struct {
+	catalogs map[language.Tag]*localeCatalog
+	tags     []language.Tag
+	matcher  language.Matcher
+}
+
+// loadLocaleBundle loads the built-in catalogs, then any *.json catalogs in
+// dir, which replace built-in ones for the same locale.
+func loadLocaleBundle(dir string) (*localeBundle, error) {
+	b := &localeBundle{catalogs: make(map[language.Tag]*localeCatalog)}
+	if err := b.loadFS(builtinLocales, "locales"); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := b.loadFS(os.DirFS(dir), "."); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := b.catalogs[defaultLocale]; !ok {
+		return nil, fmt.Errorf("no catalog for default locale %s", defaultLocale)
+	}
+
+	// The default locale goes first so that it wins when nothing matches.
+	b.tags = []language.Tag{defaultLocale}
+	for tag := range b.catalogs {
+		if tag != defaultLocale {
+			b.tags = append(b.tags, tag)
+		}
+	}
+	b.matcher = language.NewMatcher(b.tags)
+	return b, nil
+}
+
+func (b *localeBundle) loadFS(fsys fs.FS, dir string) error {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		var catalog localeCatalog
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return fmt.Errorf("locale catalog %s: %w", name, err)
+		}
+		if catalog.tag, err = language.Parse(catalog.Locale); err != nil {
+			return fmt.Errorf("locale catalog %s: %w", name, err)
+		}
+		for src, variant := range catalog.Templates {
+			if _, err := ParseTemplate(variant); err != nil {
+				return fmt.Errorf("locale catalog %s: variant of %q: %w", name, src, err)
+			}
+		}
+		b.catalogs[catalog.tag] = &catalog
+	}
+	return nil
+}
+
+// localizer looks messages up through a fallback chain of catalogs: the best
+// match for the request, its explicit fallbacks, its parent locales, and
+// finally the default locale.
+type localizer struct {
+	tag   language.Tag
+	chain []*localeCatalog
+}
+
+// localizer resolves an Accept-Language header value.
+func (b *localeBundle) localizer(acceptLanguage string) *localizer {
+	desired, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, index, _ := b.matcher.Match(desired...)
+	tag := b.tags[index]
+
+	l := &localizer{tag: tag}
+	seen := make(map[*localeCatalog]bool)
+	var add func(tag language.Tag)
+	add = func(tag language.Tag) {
+		for ; ; tag = tag.Parent() {
+			if catalog := b.catalogs[tag]; catalog != nil && !seen[catalog] {
+				seen[catalog] = true
+				l.chain = append(l.chain, catalog)
+				if catalog.Fallback != "" {
+					if fallback, err := language.Parse(catalog.Fallback); err == nil {
+						add(fallback)
+					}
+				}
+			}
+			if tag == language.Und {
+				return
+			}
+		}
+	}
+	add(tag)
+	add(defaultLocale)
+	return l
+}
+
+// message formats the plural form of key for count, filling in {name} and
+// {count}. The form follows the plural rules of the catalog the message
+// comes from, since its forms are written for that language.
+func (l *localizer) message(key, name string, count int) string {
+	for _, catalog := range l.chain {
+		forms, ok := catalog.Messages[key]
+		if !ok {
+			continue
+		}
+		form := pluralFormNames[plural.Cardinal.MatchPlural(catalog.tag, count, 0, 0, 0, 0)]
+		text, ok := forms[form]
+		if !ok {
+			text = forms["other"]
+		}
+		return strings.NewReplacer("{name}", name, "{count}", strconv.Itoa(count)).Replace(text)
+	}
+	return key
+}
+
+// possessive forms the possessive of name with the "possessive" message of
+// the first catalog that has one, or its "possessiveAfterS" variant for names
+// ending in s when that catalog defines it.
+func (l *localizer) possessive(name string) string {
+	for _, catalog := range l.chain {
+		forms, ok := catalog.Messages["possessive"]
+		if !ok {
+			continue
+		}
+		if afterS, ok := catalog.Messages["possessiveAfterS"]; ok && strings.HasSuffix(name, "s") {
+			forms = afterS
+		}
+		return strings.ReplaceAll(forms["other"], "{name}", name)
+	}
+	return name
+}
+
+// template returns the localised variant of an action text template, or src
+// itself when no catalog in the chain has one.
+func (l *localizer) template(src string) string {
+	for _, catalog := range l.chain {
+		if variant, ok := catalog.Templates[src]; ok {
+			return variant
+		}
+	}
+	return src
+}
+
+func (l *localizer) capitalize() bool {
+	return l.chain[0].Capitalize
+}
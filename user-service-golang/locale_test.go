@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocaleMessageFallbackPlurals(t *testing.T) {
+	dir := t.TempDir()
+	// Korean has no singular, and this catalog leaves "andOthers" to English.
+	catalog := `{"locale": "ko", "messages": {"you": "당신"}}`
+	if err := os.WriteFile(filepath.Join(dir, "ko.json"), []byte(catalog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	locales, err := loadLocaleBundle(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := locales.localizer("ko")
+	if got := loc.message("you", "", 1); got != "당신" {
+		t.Fatalf("you = %q", got)
+	}
+	for count, want := range map[int]string{1: "Bob and 1 other", 2: "Bob and 2 others"} {
+		if got := loc.message("andOthers", "Bob", count); got != want {
+			t.Errorf("andOthers for %d = %q, want %q", count, got, want)
+		}
+	}
+}
+
+// writeCatalogs writes locale catalogs by file name to a new directory and
+// loads a bundle with them.
+func writeCatalogs(t *testing.T, catalogs map[string]string) *localeBundle {
+	t.Helper()
+	dir := t.TempDir()
+	for name, catalog := range catalogs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(catalog), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	locales, err := loadLocaleBundle(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return locales
+}
+
+// chainLocales lists the locales of a localizer's fallback chain.
+func chainLocales(l *localizer) string {
+	var locales []string
+	for _, catalog := range l.chain {
+		locales = append(locales, catalog.Locale)
+	}
+	return strings.Join(locales, " ")
+}
+
+func TestLocaleMatching(t *testing.T) {
+	locales, err := loadLocaleBundle("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for acceptLanguage, want := range map[string]string{
+		"zh-HK":                        "zh-HK en",
+		"zh-Hant-HK":                   "zh-HK en",
+		"zh-TW":                        "zh-HK en",
+		"zh-HK;q=0.8, en;q=0.9":        "en",
+		"ja":                           "ja en",
+		"ja-JP":                        "ja en",
+		"fr, ja;q=0.5":                 "ja en",
+		"de-CH, zh-HK;q=0.8, ja;q=0.9": "ja en",
+		"en-GB":                        "en",
+		"fr":                           "en",
+		"*":                            "en",
+		"":                             "en",
+		"not a language":               "en",
+	} {
+		if got := chainLocales(locales.localizer(acceptLanguage)); got != want {
+			t.Errorf("%q: chain %q, want %q", acceptLanguage, got, want)
+		}
+	}
+}
+
+func TestLocaleFallbackChain(t *testing.T) {
+	locales := writeCatalogs(t, map[string]string{
+		"pt.json":    `{"locale": "pt", "messages": {"you": "você", "someone": "alguém", "something": "algo"}}`,
+		"pt-BR.json": `{"locale": "pt-BR", "fallback": "es", "messages": {"you": "tu"}}`,
+		"es.json":    `{"locale": "es", "fallback": "pt", "messages": {"you": "usted", "someone": "alguien"}}`,
+	})
+	loc := locales.localizer("pt-BR")
+	// The explicit fallback comes before the parent, and each catalog once.
+	if got := chainLocales(loc); got != "pt-BR es pt en" {
+		t.Fatalf("chain = %q", got)
+	}
+	for key, want := range map[string]string{
+		"you":       "tu",
+		"someone":   "alguien",
+		"something": "algo",
+		"andOthers": "Bob and 2 others",
+		"unknown":   "unknown",
+	} {
+		if got := loc.message(key, "Bob", 2); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestLocaleJapanesePlurals(t *testing.T) {
+	locales, err := loadLocaleBundle("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := locales.localizer("ja")
+	// Japanese has one form for every count, not English's singular.
+	for count, want := range map[int]string{1: "ボブと他1人", 2: "ボブと他2人", 0: "ボブと他0人"} {
+		if got := loc.message("andOthers", "ボブ", count); got != want {
+			t.Errorf("andOthers for %d = %q, want %q", count, got, want)
+		}
+	}
+	if got := loc.possessive("ボブ"); got != "ボブの" {
+		t.Errorf("possessive = %q", got)
+	}
+}
+
+func TestLocaleTemplateVariants(t *testing.T) {
+	ts := newTestServer(t)
+	for acceptLanguage, want := range map[string]string{
+		"en":    "Alice commented on Bob's post.",
+		"ja":    "AliceがBobの投稿にコメントしました。",
+		"zh-HK": "Alice喺Bob嘅帖文留咗言。",
+		"fr":    "Alice commented on Bob's post.",
+	} {
+		w := ts.do(t, http.MethodGet, "/activities", "", nil, "Accept-Language", acceptLanguage)
+		expectStatus(t, w, http.StatusOK)
+		activities := decode[[]map[string]any](t, w)
+		if len(activities) == 0 || activities[0]["actionText"] != want {
+			t.Errorf("%s: activities = %v, want %q", acceptLanguage, activities, want)
+		}
+	}
+
+	// Templates without a variant are used as they are.
+	loc := ts.locales.localizer("ja")
+	if got := loc.template("{subject} waved."); got != "{subject} waved." {
+		t.Errorf("template = %q", got)
+	}
+}
+
+func TestLocaleOverrideDirectory(t *testing.T) {
+	locales := writeCatalogs(t, map[string]string{
+		// Replaces the built-in Japanese catalog whole.
+		"ja.json":   `{"locale": "ja", "messages": {"you": "君"}, "templates": {"{subject} waved.": "{subject}が手を振った。"}}`,
+		"fr.json":   `{"locale": "fr", "capitalize": true, "messages": {"you": "vous"}}`,
+		"notes.txt": "not a catalog",
+	})
+	ja := locales.localizer("ja")
+	if got := ja.message("you", "", 1); got != "君" {
+		t.Errorf("ja you = %q", got)
+	}
+	if got := ja.message("someone", "", 1); got != "someone" {
+		t.Errorf("ja someone = %q, want the English one, the built-in catalog being replaced", got)
+	}
+	if got := ja.template("{subject} waved."); got != "{subject}が手を振った。" {
+		t.Errorf("ja template = %q", got)
+	}
+	fr := locales.localizer("fr-CA")
+	if got := fr.message("you", "", 1); got != "vous" || !fr.capitalize() {
+		t.Errorf("fr you = %q, capitalize %v", got, fr.capitalize())
+	}
+
+	for name, catalog := range map[string]string{
+		"syntax":   `{"locale": "fr",`,
+		"locale":   `{"locale": "not a locale!"}`,
+		"plural":   `{"locale": "fr", "messages": {"andOthers": {"one": "{name} et 1 autre"}}}`,
+		"template": `{"locale": "fr", "templates": {"{subject} waved.": "{sujet} a salué."}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "fr.json"), []byte(catalog), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadLocaleBundle(dir); err == nil || !strings.Contains(err.Error(), "fr.json") {
+				t.Fatalf("err = %v, want the catalog named", err)
+			}
+		})
+	}
+}
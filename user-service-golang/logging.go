@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+// maxRequestIdLength bounds the request ids taken from clients, which end up
+// in every log line of the request.
+const maxRequestIdLength = 128
+
+// logSettings are the level and format of the process logger. They can be
+// changed at any time, including by requests to /admin/logging.
+type logSettings struct {
+	level *slog.LevelVar
+	json  *atomic.Bool
+	// audit writes in the same format, but whatever the level.
+	audit *slog.Logger
+}
+
+// logHandler writes records as text or JSON, whichever the settings say at
+// the time, and adds the request id and trace id of the record's context.
+type logHandler struct {
+	settings logSettings
+	text     slog.Handler
+	json     slog.Handler
+}
+
+// setupLogging installs the process logger, which the stdlib log package
+// then writes through too.
+func setupLogging(w io.Writer, cfg loggingConfig) (logSettings, error) {
+	settings := logSettings{level: new(slog.LevelVar), json: new(atomic.Bool)}
+	if err := settings.Set(cfg.Level, cfg.Format); err != nil {
+		return logSettings{}, err
+	}
+	newLogger := func(level slog.Leveler) *slog.Logger {
+		opts := &slog.HandlerOptions{Level: level}
+		return slog.New(&logHandler{
+			settings: settings,
+			text:     slog.NewTextHandler(w, opts),
+			json:     slog.NewJSONHandler(w, opts),
+		})
+	}
+	slog.SetDefault(newLogger(settings.level))
+	settings.audit = newLogger(slog.LevelInfo).With("log", "audit")
+	return settings, nil
+}
+
+// Set changes the level and format. Empty values are left as they are.
+func (l logSettings) Set(level, format string) error {
+	var newLevel slog.Level
+	if level != "" {
+		var err error
+		if newLevel, err = parseLogLevel(level); err != nil {
+			return err
+		}
+	}
+	if format != "" && format != "text" && format != "json" {
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	if level != "" {
+		l.level.Set(newLevel)
+	}
+	if format != "" {
+		l.json.Store(format == "json")
+	}
+	return nil
+}
+
+func (l logSettings) Level() string {
+	return strings.ToLower(l.level.Level().String())
+}
+
+func (l logSettings) Format() string {
+	if l.json.Load() {
+		return "json"
+	}
+	return "text"
+}
+
+// parseLogLevel parses debug, info, warn or error, in any case.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	switch strings.ToLower(s) {
+	case "debug", "info", "warn", "error":
+		return level, level.UnmarshalText([]byte(s))
+	}
+	return level, fmt.Errorf("unknown log level %q", s)
+}
+
+func (h *logHandler) current() slog.Handler {
+	if h.settings.json.Load() {
+		return h.json
+	}
+	return h.text
+}
+
+func (h *logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.current().Enabled(ctx, level)
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestIdFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.current().Handle(ctx, r)
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logHandler{settings: h.settings, text: h.text.WithAttrs(attrs), json: h.json.WithAttrs(attrs)}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{settings: h.settings, text: h.text.WithGroup(name), json: h.json.WithGroup(name)}
+}
+
+// fatal logs an error and exits, as log.Fatal did.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type requestIdKey struct{}
+
+func requestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// requestId takes the request id from X-Request-ID, or makes one up if the
+// client sent none or an unusable one, and echoes it in the response. It is
+// logged with everything done for the request.
+func requestId(c *gin.Context) {
+	id := c.GetHeader(requestIdHeader)
+	if !validRequestId(id) {
+		id = rand.Text()
+	}
+	c.Header(requestIdHeader, id)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIdKey{}, id))
+	c.Next()
+}
+
+// validRequestId accepts short ids of printable ASCII without spaces, so a
+// client can't forge log lines with one.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// accessLog logs each request once it is done. Probes and scrapes are only
+// logged at debug level.
+func accessLog(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	level := slog.LevelInfo
+	switch status := c.Writer.Status(); {
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case c.FullPath() == "/metrics" || c.FullPath() == "/healthz" || c.FullPath() == "/readyz":
+		level = slog.LevelDebug
+	}
+	slog.LogAttrs(c.Request.Context(), level, "HTTP request",
+		slog.String("method", c.Request.Method),
+		slog.String("route", c.FullPath()),
+		slog.String("path", c.Request.URL.Path),
+		slog.Int("status", c.Writer.Status()),
+		slog.Int("bytes", max(c.Writer.Size(), 0)),
+		slog.Duration("duration", time.Since(start)),
+		slog.String("client_ip", c.ClientIP()),
+	)
+}
+
+// recovery turns a panicking handler into a 500, logging the panic and its
+// stack where gin.Recovery would print them.
+var recovery = gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+	slog.ErrorContext(c.Request.Context(), "Handler panicked",
+		slog.String("route", c.FullPath()),
+		slog.Any("panic", recovered),
+		slog.String("stack", string(debug.Stack())),
+	)
+	c.AbortWithStatusJSON(http.StatusInternalServerError, internalErrorBody(c))
+})
+
+// internalError logs err with what the request was about and answers with a
+// 500 that carries the request id rather than err, which may say more about
+// the internals than clients should know.
+func internalError(c *gin.Context, err error) {
+	attrs := []any{
+		slog.String("route", c.FullPath()),
+		slog.String("method", c.Request.Method),
+		slog.Any("error", err),
+	}
+	if id := c.Param("id"); id != "" {
+		attrs = append(attrs, slog.String("user", id))
+	}
+	if caller := callerFromContext(c); caller != nil {
+		attrs = append(attrs, slog.String("caller", caller.Id))
+	}
+	if viewer := c.Query("viewer"); viewer != "" {
+		attrs = append(attrs, slog.String("viewer", viewer))
+	}
+	if query := c.Request.URL.Query(); len(query) > 0 {
+		query.Del("access_token")
+		attrs = append(attrs, slog.String("query", query.Encode()))
+	}
+	slog.ErrorContext(c.Request.Context(), "Request failed", attrs...)
+	c.Error(err)
+	c.JSON(http.StatusInternalServerError, internalErrorBody(c))
+}
+
+func internalErrorBody(c *gin.Context) gin.H {
+	return gin.H{"error": "internal server error", "requestId": requestIdFromContext(c.Request.Context())}
+}
+
+// loggingRequest is the body of PUT /admin/logging. Fields left out stay as
+// they are.
+type loggingRequest struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+func (s *server) getLogging(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"level": s.logs.Level(), "format": s.logs.Format()})
+}
+
+func (s *server) putLogging(c *gin.Context) {
+	var req loggingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Level == "" && req.Format == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "level or format is required"})
+		return
+	}
+	if err := s.logs.Set(req.Level, req.Format); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Changed logging", "log_level", s.logs.Level(), "log_format", s.logs.Format())
+	s.getLogging(c)
+}
@@ -3,16 +3,26 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"charles/career-break-learn/user-service-golang/proto"
 
+	"github.com/XSAM/otelsql"
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"google.golang.org/grpc/health"
 	"google.golang.org/protobuf/encoding/protojson"
 )
 
@@ -21,423 +31,661 @@ var jsonMarshaler = protojson.MarshalOptions{
 	EmitUnpopulated: true,
 }
 
-var db *sql.DB
+type server struct {
+	users      UserStore
+	activities ActivityStore
+	locales    *localeBundle
+	publisher  ActivityPublisher
+	presence   *presenceTracker
+	// auth is nil when authentication is off.
+	auth *authenticator
+	// insecure allows every request when auth is nil. Without it, requests
+	// are decided as anonymous ones.
+	insecure bool
+
+	broadcaster  *activityBroadcaster
+	sseHeartbeat time.Duration
+
+	pages          *pager
+	collapseWindow time.Duration
+
+	metrics    *metrics
+	logs       logSettings
+	audit      *slog.Logger
+	health     *healthChecker
+	grpcHealth *health.Server
+	// closing is closed on shutdown to end event streams and WebSockets,
+	// which streams tracks.
+	closing   chan struct{}
+	closeOnce sync.Once
+	streams   sync.WaitGroup
+}
 
-func initDB() {
-	var err error
-	dbHost := os.Getenv("DB_HOST")
-	if dbHost == "" {
-		dbHost = "localhost"
-	}
-	dbPort := os.Getenv("DB_PORT")
-	if dbPort == "" {
-		dbPort = "5432"
-	}
-	dbUser := os.Getenv("DB_USER")
-	if dbUser == "" {
-		dbUser = "postgres"
-	}
-	dbPassword := os.Getenv("DB_PASSWORD")
-	if dbPassword == "" {
-		dbPassword = "postgres"
-	}
-	dbName := os.Getenv("DB_NAME")
-	if dbName == "" {
-		dbName = "postgres"
+func newServer(users UserStore, activities ActivityStore, locales *localeBundle, publisher ActivityPublisher, presence *presenceTracker, broadcaster *activityBroadcaster) *server {
+	return &server{
+		users:          users,
+		activities:     activities,
+		locales:        locales,
+		publisher:      publisher,
+		presence:       presence,
+		broadcaster:    broadcaster,
+		sseHeartbeat:   15 * time.Second,
+		pages:          newPager(defaultConfig().Pagination),
+		collapseWindow: defaultConfig().Feed.CollapseWindow,
+		metrics:        newMetrics(),
+		audit:          slog.Default(),
+		health:         newHealthChecker(2 * time.Second),
+		closing:        make(chan struct{}),
 	}
+}
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
+// closeStreams ends event streams and WebSockets, which would otherwise hold
+// up a graceful shutdown for as long as clients stay connected.
+func (s *server) closeStreams() {
+	s.closeOnce.Do(func() { close(s.closing) })
+}
+
+// streamContext derives the context of a long-lived stream, which also ends
+// when closeStreams is called. The caller must call done when the stream is
+// over.
+func (s *server) streamContext(ctx context.Context) (streamCtx context.Context, done func()) {
+	s.streams.Add(1)
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		s.streams.Done()
+	}
+}
 
-	db, err = sql.Open("postgres", connStr)
+func initDB(cfg databaseConfig) *sql.DB {
+	db, err := otelsql.Open("postgres", cfg.dsn(), otelsql.WithAttributes(semconv.DBSystemNamePostgreSQL))
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		fatal("Failed to connect to database", "error", err)
 	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
 	if err = db.Ping(); err != nil {
-		log.Fatal("Failed to ping database:", err)
+		fatal("Failed to ping database", "error", err)
 	}
 
-	log.Println("Successfully connected to database")
+	slog.Info("Successfully connected to database")
+	return db
 }
 
-func loadUsers() ([]*proto.User, error) {
-	rows, err := db.Query("SELECT id, name, last_seen FROM users ORDER BY id")
+func protoUserToJSON(user *proto.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                 user.Id,
+		"name":               user.Name,
+		"lastSeen":           user.LastSeen,
+		"presence":           user.Presence.String(),
+		"activityVisibility": user.ActivityVisibility.String(),
+	}
+}
+
+// getAllUsers lists a page of users in id order.
+func (s *server) getAllUsers(c *gin.Context) {
+	q, ok := s.pageQueryFromRequest(c, "users")
+	if !ok {
+		return
+	}
+	users, more, err := s.users.ListUsersPage(c.Request.Context(), q)
 	if err != nil {
-		return nil, err
+		internalError(c, err)
+		return
 	}
-	defer rows.Close()
 
-	var users []*proto.User
-	for rows.Next() {
-		var user proto.User
-		var lastSeen sql.NullTime
-		if err := rows.Scan(&user.Id, &user.Name, &lastSeen); err != nil {
-			return nil, err
-		}
-		if lastSeen.Valid {
-			user.LastSeen = lastSeen.Time.Format("2006-01-02T15:04:05Z")
+	response := &proto.ListUsersResponse{Users: users}
+	response.NextCursor, response.PrevCursor = pageCursors(s.pages, "users", q, users, userKey, more)
+	setPageLinks(c, response.NextCursor, response.PrevCursor)
+	s.respondUsers(c, response)
+}
+
+// respondUser writes a user with their current presence.
+func (s *server) respondUser(c *gin.Context, code int, user *proto.User) {
+	s.presence.apply(user, time.Now())
+	respond(c, code, user, func() (any, error) { return protoUserToJSON(user), nil })
+}
+
+// respondUsers writes a list of users with their current presence. The
+// legacy JSON shape is a bare array, without the cursors.
+func (s *server) respondUsers(c *gin.Context, response *proto.ListUsersResponse) {
+	s.presence.applyAll(response.Users)
+	respond(c, http.StatusOK, response, func() (any, error) {
+		users := make([]map[string]interface{}, len(response.Users))
+		for i, user := range response.Users {
+			users[i] = protoUserToJSON(user)
 		}
-		users = append(users, &user)
+		return users, nil
+	})
+}
+
+func (s *server) getUserByID(c *gin.Context) {
+	id := c.Param("id")
+	user, err := s.users.GetUser(c.Request.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
 	}
-	return users, rows.Err()
+	s.respondUser(c, http.StatusOK, user)
 }
 
-func loadUserActivities() ([]*proto.UserActivity, error) {
-	// Load activities
-	activityRows, err := db.Query("SELECT feed_id, action_text_template FROM user_activities ORDER BY feed_id")
+// getUserActivities lists a page of all activities, newest first, or of
+// those matching the filter parameters.
+func (s *server) getUserActivities(c *gin.Context) {
+	q, ok := s.pageQueryFromRequest(c, "activities")
+	if !ok {
+		return
+	}
+	f, ok := activityFilterFromRequest(c)
+	if !ok {
+		return
+	}
+	s.restrictToReadable(&f, callerFromContext(c), claimsFromContext(c))
+	collapse, ok := collapseFromRequest(c)
+	if !ok {
+		return
+	}
+	v, ok := s.viewerFromQuery(c)
+	if !ok {
+		return
+	}
+	activities, more, err := s.listActivitiesPage(c.Request.Context(), f, q, collapse)
 	if err != nil {
-		return nil, err
+		internalError(c, err)
+		return
 	}
-	defer activityRows.Close()
 
-	var activities []*proto.UserActivity
-	activityMap := make(map[string]*proto.UserActivity)
+	response := &proto.ListActivitiesResponse{Activities: activities}
+	response.NextCursor, response.PrevCursor = pageCursors(s.pages, "activities", q, activities, activityKey, more)
+	setPageLinks(c, response.NextCursor, response.PrevCursor)
+	respondActivities(c, response, v)
+}
 
-	for activityRows.Next() {
-		var activity proto.UserActivity
-		if err := activityRows.Scan(&activity.FeedId, &activity.ActionTextTemplate); err != nil {
-			return nil, err
-		}
-		activityMap[activity.FeedId] = &activity
-		activities = append(activities, &activity)
+// getUserActivitiesByUserID lists a page of the user's activities, newest
+// first. They are those with a subject or object the user owns, unless the
+// filter parameters say otherwise.
+func (s *server) getUserActivitiesByUserID(c *gin.Context) {
+	id := c.Param("id")
+	if !s.authorizeUserActivities(c, id) {
+		return
 	}
-
-	// Load subject referring
-	subjectRows, err := db.Query(`
-		SELECT feed_id, referring_type, referring_id, user_id 
-		FROM user_activity_subject_referring 
-		ORDER BY feed_id, referring_id
-	`)
+	list := userActivitiesList(id)
+	q, ok := s.pageQueryFromRequest(c, list)
+	if !ok {
+		return
+	}
+	f, ok := activityFilterFromRequest(c)
+	if !ok {
+		return
+	}
+	f.Id = id
+	s.restrictToReadable(&f, callerFromContext(c), claimsFromContext(c))
+	collapse, ok := collapseFromRequest(c)
+	if !ok {
+		return
+	}
+	v, ok := s.viewerFromQuery(c)
+	if !ok {
+		return
+	}
+	activities, more, err := s.listActivitiesPage(c.Request.Context(), f, q, collapse)
 	if err != nil {
-		return nil, err
+		internalError(c, err)
+		return
 	}
-	defer subjectRows.Close()
 
-	for subjectRows.Next() {
-		var feedId, referringType, referringId string
-		var userId sql.NullString
-		if err := subjectRows.Scan(&feedId, &referringType, &referringId, &userId); err != nil {
-			return nil, err
-		}
+	response := &proto.ListActivitiesResponse{Activities: activities}
+	response.NextCursor, response.PrevCursor = pageCursors(s.pages, list, q, activities, activityKey, more)
+	setPageLinks(c, response.NextCursor, response.PrevCursor)
+	respondActivities(c, response, v)
+}
 
-		activity := activityMap[feedId]
-		if activity == nil {
-			continue
-		}
+// userActivitiesList names the list of a user's activities in its cursors.
+func userActivitiesList(userId string) string {
+	return "users/" + userId + "/activities"
+}
 
-		referring := &proto.UserActivityReferring{
-			Id: referringId,
-		}
+// authorizeUserActivities checks the caller may read the activities of the
+// user with the given id. On failure it has already written the response.
+func (s *server) authorizeUserActivities(c *gin.Context, id string) bool {
+	owner, err := s.activitiesOwner(c.Request.Context(), id)
+	if err != nil {
+		internalError(c, err)
+		return false
+	}
+	return s.authorize(c, policyReadUserActivities, accessRequest{owner: owner})
+}
 
-		switch referringType {
-		case "USER":
-			referring.Type = proto.ReferringType_USER
-		case "POST":
-			referring.Type = proto.ReferringType_POST
-		default:
-			referring.Type = proto.ReferringType_USER
-		}
+// activitiesOwner loads the user whose activities are asked for, or returns
+// nil if there is no such user, whose activities anyone may read.
+func (s *server) activitiesOwner(ctx context.Context, id string) (*proto.User, error) {
+	owner, err := s.users.GetUser(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return owner, err
+}
 
-		if userId.Valid {
-			referring.UserId = userId.String
-		}
+func (s *server) postUserActivity(c *gin.Context) {
+	activity, err := bindActivity(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.authorize(c, policyCreateActivity, accessRequest{activity: activity}) {
+		return
+	}
 
-		activity.SubjectReferring = append(activity.SubjectReferring, referring)
+	v, ok := s.viewerFromQuery(c)
+	if !ok {
+		return
 	}
 
-	// Load object referring
-	objectRows, err := db.Query(`
-		SELECT feed_id, referring_type, referring_id, user_id 
-		FROM user_activity_object_referring 
-		ORDER BY feed_id, referring_id
-	`)
+	activity, err = s.createActivity(c.Request.Context(), activity)
 	if err != nil {
-		return nil, err
+		internalError(c, err)
+		return
 	}
-	defer objectRows.Close()
-
-	for objectRows.Next() {
-		var feedId, referringType, referringId string
-		var userId sql.NullString
-		if err := objectRows.Scan(&feedId, &referringType, &referringId, &userId); err != nil {
+	respond(c, http.StatusCreated, activity, func() (any, error) {
+		if err := v.load(c.Request.Context(), activity); err != nil {
 			return nil, err
 		}
+		return protoActivityToJSON(activity, v), nil
+	})
+}
 
-		activity := activityMap[feedId]
-		if activity == nil {
-			continue
-		}
-
-		referring := &proto.UserActivityReferring{
-			Id: referringId,
+// bindActivity reads a validated activity from a binary protobuf or legacy
+// JSON request body.
+func bindActivity(c *gin.Context) (*proto.UserActivity, error) {
+	if isProtobufRequest(c) {
+		var activity proto.UserActivity
+		if err := bindProtobuf(c, &activity); err != nil {
+			return nil, err
 		}
+		return &activity, validateActivity(&activity)
+	}
 
-		switch referringType {
-		case "USER":
-			referring.Type = proto.ReferringType_USER
-		case "POST":
-			referring.Type = proto.ReferringType_POST
-		default:
-			referring.Type = proto.ReferringType_USER
-		}
+	var jsonData map[string]interface{}
+	if err := c.ShouldBindJSON(&jsonData); err != nil {
+		return nil, err
+	}
+	return activityFromJSON(jsonData)
+}
 
-		if userId.Valid {
-			referring.UserId = userId.String
-		}
+// createActivity stores a validated activity, publishes it and returns it as
+// stored.
+func (s *server) createActivity(ctx context.Context, activity *proto.UserActivity) (*proto.UserActivity, error) {
+	if err := s.activities.UpsertActivity(ctx, activity); err != nil {
+		return nil, err
+	}
 
-		activity.ObjectReferring = append(activity.ObjectReferring, referring)
+	// Reload the activity
+	activity, err := s.activities.GetActivity(ctx, activity.FeedId)
+	if err != nil {
+		return nil, fmt.Errorf("reload created activity: %w", err)
 	}
 
-	return activities, nil
-}
+	s.broadcaster.Publish(activity)
 
-func protoUserToJSON(user *proto.User) map[string]interface{} {
-	return map[string]interface{}{
-		"id":       user.Id,
-		"name":     user.Name,
-		"lastSeen": user.LastSeen,
+	// The activity is stored either way, so a failed publish is not the
+	// client's problem.
+	if err := s.publisher.Publish(ctx, activity); err != nil {
+		slog.ErrorContext(ctx, "Failed to publish activity", "feed_id", activity.FeedId, "error", err)
 	}
+	return activity, nil
 }
 
-func protoActivityToJSON(activity *proto.UserActivity, you *proto.User) map[string]interface{} {
-	subjectReferring := make([]map[string]interface{}, len(activity.SubjectReferring))
-	for i, p := range activity.SubjectReferring {
-		subjectReferring[i] = map[string]interface{}{
-			"type": p.Type.String(),
-			"id":   p.Id,
-		}
+// validateActivity checks what the stores need from an activity: a feed id,
+// an id on every referring and a template that parses.
+func validateActivity(activity *proto.UserActivity) error {
+	if activity.FeedId == "" {
+		return errors.New("feedId is required")
 	}
-	var subjectText string
-	if len(activity.SubjectReferring) > 1 {
-		subjectText = "%s and %d others"
-	} else if activity.SubjectReferring[0].Id == you.Id {
-		subjectText = "%s"
-	} else {
-		subjectText = "you"
-	}
-	subjectText = fmt.Sprintf(subjectText, activity.SubjectReferring[len(activity.SubjectReferring)-1].Id)
-
-	objectReferring := make([]map[string]interface{}, len(activity.ObjectReferring))
-	for i, p := range activity.ObjectReferring {
-		objectReferring[i] = map[string]interface{}{
-			"type": p.Type.String(),
-			"id":   p.Id,
-		}
+	if err := validateReferrings(activity.SubjectReferring); err != nil {
+		return fmt.Errorf("subjectReferring: %w", err)
 	}
-	var objectText string
-	if len(activity.ObjectReferring) > 1 {
-		objectText = "%s and %d others"
-	} else if activity.ObjectReferring[0].Id == you.Id {
-		objectText = "%s"
-	} else {
-		subjectText = "you"
+	if err := validateReferrings(activity.ObjectReferring); err != nil {
+		return fmt.Errorf("objectReferring: %w", err)
 	}
-	objectText = fmt.Sprintf(objectText, activity.ObjectReferring[len(activity.ObjectReferring)-1].Id)
-
-	actionText := strings.ReplaceAll(activity.ActionTextTemplate, "{subject}", you.Name)
-	actionText = strings.ReplaceAll(actionText, "{object}", you.Name)
-	if len(actionText) > 0 {
-		actionText = strings.ToUpper(actionText[:1]) + actionText[1:]
+	if _, err := ParseTemplate(activity.ActionTextTemplate); err != nil {
+		return err
 	}
+	return nil
+}
 
-	return map[string]interface{}{
-		"feedId":             activity.FeedId,
-		"subjectReferring":   subjectReferring,
-		"objectReferring":    objectReferring,
-		"actionTextTemplate": activity.ActionTextTemplate,
-		"actionText":         actionText,
+// validateReferrings checks every referring has an id and that USER
+// referrings, which are their own user, don't claim to belong to another.
+func validateReferrings(referrings []*proto.UserActivityReferring) error {
+	for _, referring := range referrings {
+		if referring.Id == "" {
+			return errors.New("referring id is required")
+		}
+		if referring.Type == proto.ReferringType_USER && referring.UserId != "" && referring.UserId != referring.Id {
+			return fmt.Errorf("user %s can't belong to user %s", referring.Id, referring.UserId)
+		}
 	}
+	return nil
 }
 
-func getAllUsers(c *gin.Context) {
-	users, err := loadUsers()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+func activityFromJSON(jsonData map[string]interface{}) (*proto.UserActivity, error) {
+	feedId, ok := jsonData["feedId"].(string)
+	if !ok {
+		return nil, errors.New("feedId is required")
 	}
-
-	response := make([]map[string]interface{}, len(users))
-	for i, user := range users {
-		response[i] = protoUserToJSON(user)
+	actionTextTemplate, ok := jsonData["actionTextTemplate"].(string)
+	if !ok {
+		return nil, errors.New("actionTextTemplate is required")
 	}
-	c.IndentedJSON(http.StatusOK, response)
-}
 
-func getUserByID(c *gin.Context) {
-	id := c.Param("id")
-	var user proto.User
-	var lastSeen sql.NullTime
-	err := db.QueryRow("SELECT id, name, last_seen FROM users WHERE id = $1", id).
-		Scan(&user.Id, &user.Name, &lastSeen)
-	if err == sql.ErrNoRows {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "user not found"})
-		return
+	subjectReferring, err := referringsFromJSON(jsonData["subjectReferring"])
+	if err != nil {
+		return nil, fmt.Errorf("subjectReferring: %w", err)
 	}
+	objectReferring, err := referringsFromJSON(jsonData["objectReferring"])
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, fmt.Errorf("objectReferring: %w", err)
 	}
-	if lastSeen.Valid {
-		user.LastSeen = lastSeen.Time.Format("2006-01-02T15:04:05Z")
+
+	activity := &proto.UserActivity{
+		FeedId:             feedId,
+		ActionTextTemplate: actionTextTemplate,
+		SubjectReferring:   subjectReferring,
+		ObjectReferring:    objectReferring,
 	}
-	c.IndentedJSON(http.StatusOK, protoUserToJSON(&user))
+	return activity, validateActivity(activity)
 }
 
-func getUserActivities(c *gin.Context) {
-	activities, err := loadUserActivities()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+func referringsFromJSON(value interface{}) ([]*proto.UserActivityReferring, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, nil
 	}
 
-	response := make([]map[string]interface{}, len(activities))
-	for i, activity := range activities {
-		response[i] = protoActivityToJSON(activity, nil)
+	referrings := make([]*proto.UserActivityReferring, 0, len(items))
+	for _, p := range items {
+		item, ok := p.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("referring must be an object")
+		}
+		referring := &proto.UserActivityReferring{Type: proto.ReferringType_USER}
+		if typeStr, ok := item["type"].(string); ok {
+			referring.Type = parseReferringType(typeStr)
+		}
+		if referring.Id, ok = item["id"].(string); !ok {
+			return nil, errors.New("referring id is required")
+		}
+		if uid, ok := item["userId"].(string); ok {
+			referring.UserId = uid
+		}
+		referrings = append(referrings, referring)
 	}
-	c.IndentedJSON(http.StatusOK, response)
+	return referrings, nil
 }
 
-func getUserActivitiesByUserID(c *gin.Context) {
-	id := c.Param("id")
-	activities, err := loadUserActivities()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// newStores opens the configured storage backend: Postgres, or "memory",
+// which serves the seed data without a database. The returned func releases
+// the backend.
+func newStores(cfg *config) (UserStore, ActivityStore, func()) {
+	if cfg.Store == "memory" {
+		slog.Info("Using in-memory store")
+		store := newSeededMemoryStore()
+		return store, store, func() {}
 	}
 
-	var filteredActivities []map[string]interface{}
-	for _, activity := range activities {
-		for _, subjectReferring := range activity.SubjectReferring {
-			if subjectReferring.Id == id {
-				filteredActivities = append(filteredActivities, protoActivityToJSON(activity, nil))
-				goto nextActivity
-			}
-		}
-		for _, objectReferring := range activity.ObjectReferring {
-			if objectReferring.Id == id {
-				filteredActivities = append(filteredActivities, protoActivityToJSON(activity, nil))
-				goto nextActivity
-			}
-		}
-	nextActivity:
+	db := initDB(cfg.Database)
+	if cfg.AutoMigrate {
+		migrateOnStart(db)
 	}
-	c.IndentedJSON(http.StatusOK, filteredActivities)
+	store := newPostgresStore(db)
+	return store, store, func() { db.Close() }
 }
 
-func postUserActivity(c *gin.Context) {
-	var jsonData map[string]interface{}
-	if err := c.BindJSON(&jsonData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+// newPublisher publishes to the activities topic, or keeps messages in memory
+// when no brokers are configured.
+func newPublisher(cfg kafkaConfig) ActivityPublisher {
+	if len(cfg.Brokers) == 0 {
+		slog.Info("No Kafka brokers configured, keeping published activities in memory")
+		return newMemoryPublisher(cfg.ActivitiesTopic, 1000)
 	}
+	return newKafkaPublisher(cfg.Brokers, cfg.ActivitiesTopic)
+}
 
-	ctx := context.Background()
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// newConsumer reads the aggregated activities topic. There is nothing to
+// consume without brokers, so it returns nil then.
+func newConsumer(cfg kafkaConfig, activities ActivityStore, broadcaster *activityBroadcaster) (*activityConsumer, ActivitySource) {
+	if len(cfg.Brokers) == 0 {
+		return nil, nil
 	}
-	defer tx.Rollback()
+	source := newKafkaSource(cfg.Brokers, cfg.AggregatedTopic, cfg.GroupId)
+	consumer := newActivityConsumer(source, activities)
+	consumer.onStored = broadcaster.Publish
+	return consumer, source
+}
 
-	feedId := jsonData["feedId"].(string)
-	actionTextTemplate := jsonData["actionTextTemplate"].(string)
+func (s *server) routes(r *gin.Engine) {
+	r.Use(s.metrics.middleware)
+	r.GET("/metrics", s.metrics.handler())
+	r.GET("/healthz", s.getHealthz)
+	r.GET("/readyz", s.getReadyz)
+
+	// Reads are open to anonymous callers, writes are not.
+	optional := s.authenticate(false, false)
+	required := s.authenticate(true, false)
+	stream := s.authenticate(false, true)
+	// Policies that depend on more than the route are checked by the
+	// handlers.
+	r.GET("/admin/logging", required, s.require(policyManageLogging), s.getLogging)
+	r.PUT("/admin/logging", required, s.require(policyManageLogging), s.putLogging)
+	r.GET("/users", optional, s.getAllUsers)
+	r.POST("/users", required, s.require(policyCreateUser), s.postUser)
+	r.GET("/users/online", optional, s.getOnlineUsers)
+	r.GET("/users/:id", optional, s.getUserByID)
+	r.PUT("/users/:id", required, s.require(policyUpdateUser), s.putUser)
+	r.PATCH("/users/:id", required, s.require(policyUpdateUser), s.patchUser)
+	r.DELETE("/users/:id", required, s.require(policyDeleteUser), s.deleteUser)
+	r.POST("/users/:id/heartbeat", required, s.require(policyHeartbeat), s.postHeartbeat)
+	r.GET("/activities", optional, s.getUserActivities)
+	r.GET("/users/:id/activities", optional, s.getUserActivitiesByUserID)
+	r.GET("/users/:id/activities/stream", stream, s.getUserActivityStream)
+	r.GET("/ws", stream, s.getRealtime)
+	r.GET("/users/:id/feed", optional, s.getUserFeed)
+	r.POST("/users/-/activities", required, s.postUserActivity)
+}
 
-	_, err = tx.Exec("INSERT INTO user_activities (feed_id, action_text_template) VALUES ($1, $2) ON CONFLICT (feed_id) DO UPDATE SET action_text_template = $2",
-		feedId, actionTextTemplate)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+func main() {
+	cfg, args, printOnly, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
-
-	// Clear existing referring
-	_, err = tx.Exec("DELETE FROM user_activity_subject_referring WHERE feed_id = $1", feedId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		fatal("Failed to load configuration", "error", err)
 	}
-	_, err = tx.Exec("DELETE FROM user_activity_object_referring WHERE feed_id = $1", feedId)
+	logs, err := setupLogging(os.Stderr, cfg.Logging)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fatal("Failed to set up logging", "error", err)
+	}
+	if printOnly {
+		fmt.Print(cfg.Redacted())
 		return
 	}
+	if len(args) > 0 && args[0] == "migrate" {
+		runMigrate(cfg, args[1:])
+		return
+	}
+	slog.Info("Effective configuration", "config", cfg.Redacted())
 
-	// Insert subject referring
-	if subjectReferring, ok := jsonData["subjectReferring"].([]interface{}); ok {
-		for _, p := range subjectReferring {
-			subjectReferringItem := p.(map[string]interface{})
-			referringType := "USER"
-			if typeStr, ok := subjectReferringItem["type"].(string); ok {
-				referringType = strings.ToUpper(typeStr)
-			}
-			referringId := subjectReferringItem["id"].(string)
-			var userId sql.NullString
-			if uid, ok := subjectReferringItem["userId"].(string); ok {
-				userId = sql.NullString{String: uid, Valid: true}
-			}
+	shutdownTracing, err := setupTracing(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
+	}
 
-			_, err = tx.Exec("INSERT INTO user_activity_subject_referring (feed_id, referring_type, referring_id, user_id) VALUES ($1, $2, $3, $4)",
-				feedId, referringType, referringId, userId)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-		}
+	locales, err := loadLocaleBundle(cfg.LocalesDir)
+	if err != nil {
+		fatal("Failed to load locale catalogs", "error", err)
 	}
 
-	// Insert object referring
-	if objectReferring, ok := jsonData["objectReferring"].([]interface{}); ok {
-		for _, p := range objectReferring {
-			objectReferringItem := p.(map[string]interface{})
-			referringType := "USER"
-			if typeStr, ok := objectReferringItem["type"].(string); ok {
-				referringType = strings.ToUpper(typeStr)
-			}
-			referringId := objectReferringItem["id"].(string)
-			var userId sql.NullString
-			if uid, ok := objectReferringItem["userId"].(string); ok {
-				userId = sql.NullString{String: uid, Valid: true}
-			}
+	users, activities, closeStores := newStores(cfg)
+	publisher := newPublisher(cfg.Kafka)
 
-			_, err = tx.Exec("INSERT INTO user_activity_object_referring (feed_id, referring_type, referring_id, user_id) VALUES ($1, $2, $3, $4)",
-				feedId, referringType, referringId, userId)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
+	// Background workers run until shutdown, after the servers have stopped
+	// taking requests.
+	ctx, cancel := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+
+	broadcaster := newActivityBroadcaster(1000, 64)
+
+	// Activities stored by other instances, or by the Java processor, reach
+	// the broadcaster through LISTEN/NOTIFY.
+	if store, ok := activities.(*postgresStore); ok {
+		listener := newActivityChangeListener(cfg.Database.dsn(), store, broadcaster.Publish,
+			cfg.Realtime.ActivityChangesOverlap)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if err := listener.Run(ctx); err != nil {
+				slog.Error("Activity change listener stopped", "error", err)
 			}
-		}
+		}()
 	}
 
-	if err = tx.Commit(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if consumer, source := newConsumer(cfg.Kafka, activities, broadcaster); consumer != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			defer source.Close()
+			consumer.Run(ctx)
+		}()
 	}
 
-	// Reload the activity
-	activities, err := loadUserActivities()
+	presence := newPresenceTracker(users, cfg.Presence.OnlineWithin, cfg.Presence.AwayWithin, cfg.Presence.FlushInterval)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		presence.Run(ctx)
+	}()
+
+	srv := newServer(users, activities, locales, publisher, presence, broadcaster)
+	srv.sseHeartbeat = cfg.Realtime.SSEHeartbeat
+	srv.logs = logs
+	srv.pages = newPager(cfg.Pagination)
+	srv.collapseWindow = cfg.Feed.CollapseWindow
+	if cfg.Pagination.CursorSecret == "" {
+		slog.Warn("No cursor secret configured, page cursors only work on this instance until it restarts")
+	}
+	srv.audit = logs.audit
+	if srv.auth, err = newAuthenticator(cfg.Auth, users); err != nil {
+		fatal("Failed to load authentication keys", "error", err)
+	}
+	if srv.auth == nil {
+		srv.insecure = cfg.Auth.Insecure
+		slog.Warn("No authentication keys configured, serving every request anonymously and allowing it")
+	}
+	if store, ok := users.(*postgresStore); ok {
+		srv.health.Register("database", store.Ping)
+		srv.metrics.instrumentStore(store)
+	}
+	if len(cfg.Kafka.Brokers) > 0 {
+		srv.health.Register("kafka", pingKafka(cfg.Kafka.Brokers))
+	}
+
+	grpcListener, err := net.Listen("tcp", cfg.GRPCAddr)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		fatal("Failed to listen for gRPC", "error", err)
 	}
-	for _, activity := range activities {
-		if activity.FeedId == feedId {
-			c.IndentedJSON(http.StatusCreated, protoActivityToJSON(activity, nil))
-			return
+	grpcServer := srv.newGRPCServer()
+	go func() {
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			slog.Error("gRPC server stopped", "error", err)
+		}
+	}()
+	slog.Info("Serving gRPC", "addr", cfg.GRPCAddr)
+
+	r := gin.New()
+	r.Use(requestId, accessLog, recovery)
+	r.Use(otelgin.Middleware(cfg.Tracing.ServiceName, otelgin.WithFilter(func(req *http.Request) bool {
+		// Probes and scrapes would drown out the traces worth looking at.
+		return req.URL.Path != "/metrics" && req.URL.Path != "/healthz" && req.URL.Path != "/readyz"
+	})))
+	srv.routes(r)
+	httpServer := &http.Server{Addr: cfg.HTTPAddr, Handler: r}
+	httpServer.RegisterOnShutdown(srv.closeStreams)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	slog.Info("Serving HTTP", "addr", cfg.HTTPAddr)
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	exitCode := 0
+	select {
+	case <-signals.Done():
+		slog.Info("Shutting down")
+	case err := <-serveErr:
+		slog.Error("HTTP server failed", "error", err)
+		exitCode = 1
+	}
+
+	// Stop taking traffic, then let in-flight requests finish within the
+	// drain timeout, then stop the workers, which may still write, and only
+	// then close the publisher and the database.
+	srv.health.Drain()
+	srv.grpcHealth.Shutdown()
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelDrain()
+
+	if err := httpServer.Shutdown(drainCtx); err != nil {
+		slog.Warn("HTTP server did not drain", "error", err)
+	}
+	srv.closeStreams()
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	streamsClosed := make(chan struct{})
+	go func() {
+		srv.streams.Wait()
+		close(streamsClosed)
+	}()
+	for grpcStopped != nil || streamsClosed != nil {
+		select {
+		case <-grpcStopped:
+			grpcStopped = nil
+		case <-streamsClosed:
+			streamsClosed = nil
+		case <-drainCtx.Done():
+			slog.Warn("Drain timeout passed, stopping anyway")
+			grpcServer.Stop()
+			grpcStopped, streamsClosed = nil, nil
 		}
 	}
 
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload created activity"})
-}
-
-func main() {
-	initDB()
-	defer db.Close()
-
-	r := gin.Default()
-	r.GET("/users", getAllUsers)
-	r.GET("/users/:id", getUserByID)
-	r.GET("/activities", getUserActivities)
-	r.GET("/users/:id/activities", getUserActivitiesByUserID)
-	r.POST("/users/-/activities", postUserActivity)
-	r.Run()
+	cancel()
+	workers.Wait()
+	if err := publisher.Close(); err != nil {
+		slog.Error("Failed to close publisher", "error", err)
+	}
+	closeStores()
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	slog.Info("Stopped")
+	os.Exit(exitCode)
 }
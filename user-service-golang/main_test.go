@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testServer is a server on the seeded memory store with authentication on,
+// as main wires it up, minus the network.
+type testServer struct {
+	*server
+	store     *memoryStore
+	publisher *memoryPublisher
+	router    *gin.Engine
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	store := newSeededMemoryStore()
+	locales, err := loadLocaleBundle("locales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher := newMemoryPublisher("user-activities", 100)
+	presence := newPresenceTracker(store, 5*time.Minute, 15*time.Minute, time.Minute)
+	srv := newServer(store, store, locales, publisher, presence, newActivityBroadcaster(100, 16))
+	srv.audit = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if srv.auth, err = newAuthenticator(authConfig{HS256Secret: testSecret}, store); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(requestId, recovery)
+	srv.routes(r)
+	return &testServer{server: srv, store: store, publisher: publisher, router: r}
+}
+
+// token signs a bearer token for subject with the given scope.
+func token(t *testing.T, subject, scope string) string {
+	t.Helper()
+	claims := jwt.MapClaims{"sub": subject, "exp": time.Now().Add(time.Hour).Unix()}
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// do serves a request. body is sent as JSON unless it is nil, and token as
+// the bearer token unless it is empty.
+func (ts *testServer) do(t *testing.T, method, path, token string, body any, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	return w
+}
+
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	return v
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, code int) {
+	t.Helper()
+	if w.Code != code {
+		t.Fatalf("status = %d, want %d: %s", w.Code, code, w.Body)
+	}
+}
+
+func feedIds(activities []map[string]any) []string {
+	ids := make([]string, len(activities))
+	for i, activity := range activities {
+		ids[i], _ = activity["feedId"].(string)
+	}
+	return ids
+}
+
+func activityBody(feedId, template, subject string, objects ...map[string]any) map[string]any {
+	return map[string]any{
+		"feedId":             feedId,
+		"actionTextTemplate": template,
+		"subjectReferring":   []map[string]any{{"type": "USER", "id": subject}},
+		"objectReferring":    objects,
+	}
+}
+
+func TestGetUsers(t *testing.T) {
+	ts := newTestServer(t)
+
+	w := ts.do(t, http.MethodGet, "/users", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	users := decode[[]map[string]any](t, w)
+	if len(users) != 3 || users[0]["name"] != "Alice" {
+		t.Fatalf("users = %v", users)
+	}
+
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/1", "", nil), http.StatusOK)
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/nobody", "", nil), http.StatusNotFound)
+}
+
+func TestGetUsersPages(t *testing.T) {
+	ts := newTestServer(t)
+
+	w := ts.do(t, http.MethodGet, "/users?limit=2", "", nil, "Accept", mimeJSON)
+	expectStatus(t, w, http.StatusOK)
+	first := decode[map[string]any](t, w)
+	if users := first["users"].([]any); len(users) != 2 {
+		t.Fatalf("first page has %d users", len(users))
+	}
+	next, _ := first["nextCursor"].(string)
+	if next == "" || first["prevCursor"] != "" {
+		t.Fatalf("first page cursors: next %q, prev %q", next, first["prevCursor"])
+	}
+	if link := w.Header().Get("Link"); !strings.Contains(link, `rel="next"`) {
+		t.Fatalf("Link = %q", link)
+	}
+
+	w = ts.do(t, http.MethodGet, "/users?limit=2&cursor="+next, "", nil, "Accept", mimeJSON)
+	expectStatus(t, w, http.StatusOK)
+	second := decode[map[string]any](t, w)
+	users := second["users"].([]any)
+	if len(users) != 1 || users[0].(map[string]any)["id"] != "3" {
+		t.Fatalf("second page = %v", users)
+	}
+	if second["nextCursor"] != "" || second["prevCursor"] == "" {
+		t.Fatalf("second page cursors: next %q, prev %q", second["nextCursor"], second["prevCursor"])
+	}
+
+	expectStatus(t, ts.do(t, http.MethodGet, "/users?cursor=forged", "", nil), http.StatusBadRequest)
+	expectStatus(t, ts.do(t, http.MethodGet, "/activities?cursor="+next, "", nil), http.StatusBadRequest)
+	expectStatus(t, ts.do(t, http.MethodGet, "/users?limit=0", "", nil), http.StatusBadRequest)
+}
+
+func TestGetUsersProtobuf(t *testing.T) {
+	ts := newTestServer(t)
+
+	w := ts.do(t, http.MethodGet, "/users", "", nil, "Accept", mimeProtobuf)
+	expectStatus(t, w, http.StatusOK)
+	var response proto.ListUsersResponse
+	if err := protobuf.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Users) != 3 {
+		t.Fatalf("users = %v", response.Users)
+	}
+}
+
+func TestPostUserActivity(t *testing.T) {
+	ts := newTestServer(t)
+	body := activityBody("like1", "{subject} liked {object} post.", "1",
+		map[string]any{"type": "POST", "id": "77", "userId": "2"})
+
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", "", body), http.StatusUnauthorized)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", token(t, "2", ""), body), http.StatusForbidden)
+
+	w := ts.do(t, http.MethodPost, "/users/-/activities", token(t, "1", ""), body)
+	expectStatus(t, w, http.StatusCreated)
+	if got := decode[map[string]any](t, w)["actionText"]; got != "You liked Bob's post." {
+		t.Fatalf("actionText = %q", got)
+	}
+	if _, err := ts.store.GetActivity(context.Background(), "like1"); err != nil {
+		t.Fatalf("activity not stored: %v", err)
+	}
+	if messages := ts.publisher.Messages(); len(messages) != 1 {
+		t.Fatalf("published %d messages, want 1", len(messages))
+	}
+
+	// Subjects other than users don't make the caller one, whoever they
+	// claim to belong to.
+	spoofed := activityBody("post1", "{subject} was posted.", "1")
+	spoofed["subjectReferring"] = []map[string]any{{"type": "POST", "id": "5", "userId": "1"}}
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", token(t, "1", ""), spoofed), http.StatusForbidden)
+
+	// Services may create activities on anyone's behalf.
+	body["feedId"] = "like2"
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", token(t, "processor", scopeActivitiesWriteAny), body), http.StatusCreated)
+}
+
+func TestPostUserActivityInvalid(t *testing.T) {
+	ts := newTestServer(t)
+	alice := token(t, "1", "")
+
+	for name, body := range map[string]map[string]any{
+		"no feed id":           {"actionTextTemplate": "{subject} waved"},
+		"bad template":         activityBody("bad", "{subject waved", "1"),
+		"no referring id":      {"feedId": "x", "actionTextTemplate": "{subject} waved", "subjectReferring": []map[string]any{{"type": "USER"}}},
+		"user of another user": {"feedId": "x", "actionTextTemplate": "{subject} waved", "subjectReferring": []map[string]any{{"type": "USER", "id": "2", "userId": "1"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", alice, body), http.StatusBadRequest)
+		})
+	}
+}
+
+func TestUserWrites(t *testing.T) {
+	ts := newTestServer(t)
+	alice, bob, admin := token(t, "1", ""), token(t, "2", ""), token(t, "admin", scopeAdmin)
+
+	expectStatus(t, ts.do(t, http.MethodPost, "/users", alice, map[string]any{"name": "Dave"}), http.StatusForbidden)
+	w := ts.do(t, http.MethodPost, "/users", admin, map[string]any{"name": "Dave"})
+	expectStatus(t, w, http.StatusCreated)
+	if location := w.Header().Get("Location"); !strings.HasPrefix(location, "/users/") {
+		t.Fatalf("Location = %q", location)
+	}
+	expectStatus(t, ts.do(t, http.MethodPost, "/users", admin, map[string]any{"name": "Dave"}), http.StatusConflict)
+
+	expectStatus(t, ts.do(t, http.MethodPatch, "/users/1", bob, map[string]any{"name": "Mallory"}), http.StatusForbidden)
+	w = ts.do(t, http.MethodPatch, "/users/-", alice, map[string]any{"name": "Alicia"})
+	expectStatus(t, w, http.StatusOK)
+	if name := decode[map[string]any](t, w)["name"]; name != "Alicia" {
+		t.Fatalf("name = %q", name)
+	}
+
+	expectStatus(t, ts.do(t, http.MethodDelete, "/users/3", alice, nil), http.StatusForbidden)
+	expectStatus(t, ts.do(t, http.MethodDelete, "/users/3", admin, nil), http.StatusNoContent)
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/3", "", nil), http.StatusNotFound)
+}
+
+func TestAuthenticationOff(t *testing.T) {
+	ts := newTestServer(t)
+	ts.auth = nil
+
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/1", "", nil), http.StatusOK)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users", "", map[string]any{"name": "Dave"}), http.StatusUnauthorized)
+	expectStatus(t, ts.do(t, http.MethodDelete, "/users/3", "", nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/1/heartbeat", "", nil), http.StatusUnauthorized)
+	if ts.allow(context.Background(), policyDeleteUser, accessRequest{owner: &proto.User{Id: "3"}}) {
+		t.Fatal("anonymous delete allowed")
+	}
+
+	ts.insecure = true
+	expectStatus(t, ts.do(t, http.MethodPost, "/users", "", map[string]any{"name": "Dave"}), http.StatusCreated)
+	expectStatus(t, ts.do(t, http.MethodDelete, "/users/3", "", nil), http.StatusNoContent)
+}
+
+func TestPrivateUserActivities(t *testing.T) {
+	ts := newTestServer(t)
+	alice, bob := token(t, "1", ""), token(t, "2", "")
+
+	expectStatus(t, ts.do(t, http.MethodPatch, "/users/1", alice, map[string]any{"activityVisibility": "PRIVATE"}), http.StatusOK)
+
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/1/activities", "", nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/1/activities", bob, nil), http.StatusForbidden)
+	w := ts.do(t, http.MethodGet, "/users/1/activities", alice, nil)
+	expectStatus(t, w, http.StatusOK)
+	if ids := feedIds(decode[[]map[string]any](t, w)); len(ids) != 1 || ids[0] != "feed1" {
+		t.Fatalf("activities = %v", ids)
+	}
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/1/activities", token(t, "auditor", scopeActivitiesReadAny), nil), http.StatusOK)
+}
+
+func TestPrivateActivitiesLeftOutOfLists(t *testing.T) {
+	ts := newTestServer(t)
+	alice, bob := token(t, "1", ""), token(t, "2", "")
+	// feed1 is Alice's comment on Bob's post.
+	expectStatus(t, ts.do(t, http.MethodPatch, "/users/1", alice, map[string]any{"activityVisibility": "PRIVATE"}), http.StatusOK)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", bob, activityBody("wave1", "{subject} waved.", "2")), http.StatusCreated)
+
+	for _, path := range []string{"/activities", "/users/2/activities", "/users/2/feed"} {
+		for name, test := range map[string]struct {
+			token string
+			want  string
+		}{
+			"anonymous": {"", "wave1"},
+			"bob":       {bob, "wave1"},
+			"alice":     {alice, "wave1,feed1"},
+			"auditor":   {token(t, "auditor", scopeActivitiesReadAny), "wave1,feed1"},
+		} {
+			t.Run(path+" "+name, func(t *testing.T) {
+				w := ts.do(t, http.MethodGet, path, test.token, nil)
+				expectStatus(t, w, http.StatusOK)
+				if got := strings.Join(feedIds(decode[[]map[string]any](t, w)), ","); got != test.want {
+					t.Fatalf("activities = %s, want %s", got, test.want)
+				}
+			})
+		}
+	}
+}
+
+func TestUserActivitiesFilters(t *testing.T) {
+	ts := newTestServer(t)
+	service := token(t, "processor", scopeActivitiesWriteAny)
+	post := map[string]any{"type": "POST", "id": "77", "userId": "2"}
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", service, activityBody("like1", "{subject} liked {object} post.", "3", post)), http.StatusCreated)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", service, activityBody("wave1", "{subject} waved.", "2")), http.StatusCreated)
+
+	for query, want := range map[string][]string{
+		"":                                    {"wave1", "like1", "feed1"},
+		"?as=subject":                         {"wave1"},
+		"?as=object":                          {"like1", "feed1"},
+		"?as=object&referringType=USER":       {},
+		"?template=%7Bsubject%7D+waved.":      {"wave1"},
+		"?match=referring":                    {"wave1"},
+		"?until=2000-01-01T00:00:00Z":         {},
+		"?since=2000-01-01T00:00:00Z&limit=1": {"wave1"},
+	} {
+		t.Run(query, func(t *testing.T) {
+			w := ts.do(t, http.MethodGet, "/users/2/activities"+query, "", nil)
+			expectStatus(t, w, http.StatusOK)
+			if got := feedIds(decode[[]map[string]any](t, w)); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Fatalf("activities = %v, want %v", got, want)
+			}
+		})
+	}
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/2/activities?as=everyone", "", nil), http.StatusBadRequest)
+}
+
+func TestGetUserFeedPages(t *testing.T) {
+	ts := newTestServer(t)
+	service := token(t, "processor", scopeActivitiesWriteAny)
+	post := map[string]any{"type": "POST", "id": "77", "userId": "2"}
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", service, activityBody("like1", "{subject} liked {object} post.", "3", post)), http.StatusCreated)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", service, activityBody("wave1", "{subject} waved.", "1")), http.StatusCreated)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", service, activityBody("wave2", "{subject} waved.", "2")), http.StatusCreated)
+
+	w := ts.do(t, http.MethodGet, "/users/2/feed?limit=2", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if got := feedIds(decode[[]map[string]any](t, w)); strings.Join(got, ",") != "wave2,like1" {
+		t.Fatalf("first page = %v", got)
+	}
+	next := regexp.MustCompile(`<([^>]*)>; rel="next"`).FindStringSubmatch(w.Header().Get("Link"))
+	if next == nil {
+		t.Fatalf("Link = %q", w.Header().Get("Link"))
+	}
+	w = ts.do(t, http.MethodGet, next[1], "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if got := feedIds(decode[[]map[string]any](t, w)); strings.Join(got, ",") != "feed1" {
+		t.Fatalf("second page = %v", got)
+	}
+	expectStatus(t, ts.do(t, http.MethodGet, "/users/1/feed?"+strings.SplitN(next[1], "?", 2)[1], "", nil), http.StatusBadRequest)
+}
+
+func TestMetrics(t *testing.T) {
+	ts := newTestServer(t)
+	ts.do(t, http.MethodGet, "/users", "", nil)
+
+	w := ts.do(t, http.MethodGet, "/metrics", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if want := `user_service_http_requests_total{code="200",method="GET",route="/users"} 1`; !strings.Contains(w.Body.String(), want) {
+		t.Fatalf("metrics lack %s", want)
+	}
+}
+
+// failingGetStore loses activities between storing and reloading them.
+type failingGetStore struct {
+	*memoryStore
+}
+
+func (failingGetStore) GetActivity(ctx context.Context, feedId string) (*proto.UserActivity, error) {
+	return nil, ErrNotFound
+}
+
+func TestCreateActivityReloadFails(t *testing.T) {
+	ts := newTestServer(t)
+	ts.activities = failingGetStore{ts.store}
+
+	_, err := ts.createActivity(context.Background(), &proto.UserActivity{FeedId: "lost", ActionTextTemplate: "{subject} waved."})
+	if err == nil || !strings.Contains(err.Error(), "reload") || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want the reload failure wrapping ErrNotFound", err)
+	}
+}
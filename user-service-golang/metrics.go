@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// renderedActivitiesKey is the gin context key under which handlers report
+// how many activities they rendered.
+const renderedActivitiesKey = "metrics.renderedActivities"
+
+// metrics holds the service's Prometheus metrics in a registry of its own,
+// served at /metrics.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests           *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	handlerErrors      *prometheus.CounterVec
+	renderedActivities *prometheus.HistogramVec
+	queryDuration      *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "user_service",
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "user_service",
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		handlerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "user_service",
+			Name:      "handler_errors_total",
+			Help:      "Responses with a 4xx or 5xx status by handler and status class.",
+		}, []string{"handler", "class"}),
+		renderedActivities: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "user_service",
+			Name:      "rendered_activities",
+			Help:      "Activities rendered per request by route.",
+			Buckets:   []float64{0, 1, 5, 10, 25, 50, 100, 250, 500, 1000},
+		}, []string{"route"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "user_service",
+			Name:      "db_query_duration_seconds",
+			Help:      "Database query latency by query.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"query"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.handlerErrors,
+		m.renderedActivities,
+		m.queryDuration,
+	)
+	return m
+}
+
+// instrumentStore times the store's queries and exports its pool stats.
+func (m *metrics) instrumentStore(store *postgresStore) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(store.db, "postgres"))
+	store.queryDuration = m.queryDuration
+}
+
+// middleware records every request once it has been handled.
+func (m *metrics) middleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route, handler := c.FullPath(), handlerName(c)
+	if route == "" {
+		route, handler = "unmatched", "unmatched"
+	}
+	code := c.Writer.Status()
+	m.requests.WithLabelValues(c.Request.Method, route, strconv.Itoa(code)).Inc()
+	m.requestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	if code >= 400 {
+		m.handlerErrors.WithLabelValues(handler, strconv.Itoa(code/100)+"xx").Inc()
+	}
+	if n, ok := c.Get(renderedActivitiesKey); ok {
+		m.renderedActivities.WithLabelValues(route).Observe(float64(n.(int)))
+	}
+}
+
+func (m *metrics) handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// handlerName shortens gin's "main.(*server).getUserFeed-fm" to
+// "getUserFeed".
+func handlerName(c *gin.Context) string {
+	name := c.HandlerName()
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.TrimSuffix(name, "-fm")
+}
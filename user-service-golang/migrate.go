@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed db/migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the pg_advisory_lock key held while migrating, so that
+// instances starting together don't migrate at the same time.
+const migrationLockKey = 0x75736572_73766321
+
+// migration is a numbered schema change, read from NNNN_name.up.sql and
+// NNNN_name.down.sql.
+type migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrationStatus is a migration and when, if ever, it was applied. Missing
+// marks a version recorded in the database that this binary doesn't know.
+type migrationStatus struct {
+	migration
+	AppliedAt *time.Time
+	Missing   bool
+}
+
+// loadMigrations reads the migrations in dir, ordered by version.
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*migration)
+	for _, entry := range entries {
+		file := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: want NNNN_name.up.sql or NNNN_name.down.sql", file)
+		}
+		number, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(number, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: bad version %q", file, number)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d is named both %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up migration", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrator applies migrations, recording them in schema_migrations. Each
+// migration runs in its own transaction.
+type migrator struct {
+	db         *sql.DB
+	migrations []migration
+}
+
+func newMigrator(db *sql.DB) (*migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, "db/migrations")
+	if err != nil {
+		return nil, err
+	}
+	return &migrator{db: db, migrations: migrations}, nil
+}
+
+// Up applies pending migrations up to and including target, or all of them
+// when target is 0, and returns those it applied.
+func (m *migrator) Up(ctx context.Context, target int64) ([]migration, error) {
+	var done []migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if target != 0 && mig.Version > target {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", mig.Version, mig.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migrate up to %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations and returns those it
+// reverted.
+func (m *migrator) Down(ctx context.Context, steps int) ([]migration, error) {
+	var done []migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down migration", mig.Version, mig.Name)
+			}
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", mig.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migrate down from %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every known migration, plus any applied ones this binary
+// doesn't know, in version order.
+func (m *migrator) Status(ctx context.Context) ([]migrationStatus, error) {
+	var statuses []migrationStatus
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			status := migrationStatus{migration: mig}
+			if at, ok := applied[mig.Version]; ok {
+				status.AppliedAt = &at
+				delete(applied, mig.Version)
+			}
+			statuses = append(statuses, status)
+		}
+		for version, at := range applied {
+			statuses = append(statuses, migrationStatus{migration: migration{Version: version}, AppliedAt: &at, Missing: true})
+		}
+		return nil
+	})
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, err
+}
+
+// locked runs fn on a connection holding the migration lock, with
+// schema_migrations in place. Advisory locks belong to a session, so
+// everything has to happen on that one connection.
+func (m *migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", int64(migrationLockKey)); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", int64(migrationLockKey))
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// runMigrate implements the migrate subcommand:
+//
+//	migrate up [version]   apply pending migrations, up to version if given
+//	migrate down [steps]   revert the last steps migrations, 1 by default
+//	migrate status         list migrations and when they were applied
+func runMigrate(cfg *config, args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "usage: migrate up [version] | down [steps] | status")
+		os.Exit(2)
+	}
+	var n int64
+	if len(args) == 2 {
+		var err error
+		if n, err = strconv.ParseInt(args[1], 10, 64); err != nil || n < 0 {
+			fatal("Bad argument to migrate", "command", args[0], "argument", args[1])
+		}
+	}
+
+	if cfg.Store != "postgres" {
+		fatal("Nothing to migrate", "store", cfg.Store)
+	}
+	db := initDB(cfg.Database)
+	defer db.Close()
+	m, err := newMigrator(db)
+	if err != nil {
+		fatal("Failed to load migrations", "error", err)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx, n)
+		for _, mig := range applied {
+			fmt.Printf("applied %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			fatal("Failed to migrate database", "error", err)
+		}
+	case "down":
+		if len(args) == 1 {
+			n = 1
+		}
+		reverted, err := m.Down(ctx, int(n))
+		for _, mig := range reverted {
+			fmt.Printf("reverted %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			fatal("Failed to migrate database", "error", err)
+		}
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			fatal("Failed to migrate database", "error", err)
+		}
+		for _, status := range statuses {
+			switch {
+			case status.Missing:
+				fmt.Printf("%04d  applied %s, not in this binary\n", status.Version, status.AppliedAt.Format(time.RFC3339))
+			case status.AppliedAt != nil:
+				fmt.Printf("%04d_%s  applied %s\n", status.Version, status.Name, status.AppliedAt.Format(time.RFC3339))
+			default:
+				fmt.Printf("%04d_%s  pending\n", status.Version, status.Name)
+			}
+		}
+	default:
+		fatal("Unknown migrate command", "command", args[0])
+	}
+}
+
+// migrateOnStart applies pending migrations before the service starts.
+func migrateOnStart(db *sql.DB) {
+	m, err := newMigrator(db)
+	if err != nil {
+		fatal("Failed to load migrations", "error", err)
+	}
+	applied, err := m.Up(context.Background(), 0)
+	for _, mig := range applied {
+		slog.Info("Applied migration", "version", mig.Version, "name", mig.Name)
+	}
+	if err != nil {
+		fatal("Failed to migrate database", "error", err)
+	}
+}
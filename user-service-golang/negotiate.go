@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// Media types the REST endpoints speak. Legacy JSON is the hand-built map
+// shape (with rendered actionText) and stays the default for clients that
+// don't ask for anything specific.
+const (
+	mimeLegacyJSON = "application/vnd.user-service.legacy+json"
+	mimeJSON       = "application/json"
+	mimeProtobuf   = "application/x-protobuf"
+)
+
+const maxProtobufBody = 1 << 20
+
+// respond writes msg as binary protobuf or canonical protojson, or legacy()
+// in the legacy JSON shape, whichever Accept prefers.
+func respond(c *gin.Context, code int, msg protobuf.Message, legacy func() (any, error)) {
+	c.Header("Vary", "Accept")
+	switch c.NegotiateFormat(mimeLegacyJSON, mimeJSON, mimeProtobuf) {
+	case mimeLegacyJSON:
+		body, err := legacy()
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		c.IndentedJSON(code, body)
+	case mimeJSON:
+		data, err := jsonMarshaler.Marshal(msg)
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		c.Data(code, mimeJSON, data)
+	case mimeProtobuf:
+		data, err := protobuf.Marshal(msg)
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		c.Data(code, mimeProtobuf, data)
+	default:
+		c.JSON(http.StatusNotAcceptable, gin.H{"error": "supported types are " + mimeJSON + ", " + mimeProtobuf + " and " + mimeLegacyJSON})
+	}
+}
+
+// isProtobufRequest reports whether the request body is binary protobuf.
+func isProtobufRequest(c *gin.Context) bool {
+	return c.ContentType() == mimeProtobuf
+}
+
+// bindProtobuf reads a binary protobuf request body into msg.
+func bindProtobuf(c *gin.Context, msg protobuf.Message) error {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxProtobufBody))
+	if err != nil {
+		return err
+	}
+	return protobuf.Unmarshal(data, msg)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pageKey is the position of an entry in a keyset-paginated list. Users go
+// by Id alone; activities go newest first by CreatedAt, then by Id (the
+// feed id), both descending.
+type pageKey struct {
+	CreatedAt time.Time
+	Id        string
+}
+
+// pageQuery asks for up to Limit entries after After or, going backwards, up
+// to Limit entries before Before. With neither it starts at the beginning.
+type pageQuery struct {
+	After  *pageKey
+	Before *pageKey
+	Limit  int
+}
+
+func userKey(user *proto.User) pageKey {
+	return pageKey{Id: user.Id}
+}
+
+func activityKey(activity *proto.UserActivity) pageKey {
+	createdAt, _ := time.Parse(createdAtLayout, activity.CreatedAt)
+	return pageKey{CreatedAt: createdAt, Id: activity.FeedId}
+}
+
+// compareUserKeys and compareActivityKeys order keys as their lists do.
+func compareUserKeys(a, b pageKey) int {
+	return strings.Compare(a.Id, b.Id)
+}
+
+func compareActivityKeys(a, b pageKey) int {
+	if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+		return c
+	}
+	return strings.Compare(b.Id, a.Id)
+}
+
+// pageOf cuts the page q asks for out of entries sorted by compare.
+func pageOf[T any](entries []T, key func(T) pageKey, compare func(a, b pageKey) int, q pageQuery) ([]T, bool) {
+	if q.Before != nil {
+		end := sort.Search(len(entries), func(i int) bool { return compare(key(entries[i]), *q.Before) >= 0 })
+		start := max(end-q.Limit, 0)
+		return entries[start:end], start > 0
+	}
+	start := 0
+	if q.After != nil {
+		start = sort.Search(len(entries), func(i int) bool { return compare(key(entries[i]), *q.After) > 0 })
+	}
+	end := min(start+q.Limit, len(entries))
+	return entries[start:end], end < len(entries)
+}
+
+var errBadCursor = errors.New("invalid cursor")
+
+// cursor is what a page cursor stands for: the key to continue from, which
+// way, and the list it belongs to.
+type cursor struct {
+	List      string    `json:"l"`
+	Backward  bool      `json:"b,omitempty"`
+	CreatedAt time.Time `json:"t,omitzero"`
+	Id        string    `json:"i"`
+}
+
+// pager turns page requests into page queries and pages into cursors.
+// Cursors are signed, so clients can't make up positions or carry them from
+// one list to another.
+type pager struct {
+	defaultSize int
+	maxSize     int
+	key         []byte
+}
+
+// newPager signs cursors with secret, or with a random key if it is empty,
+// in which case cursors don't outlive the process.
+func newPager(cfg paginationConfig) *pager {
+	key := []byte(cfg.CursorSecret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &pager{defaultSize: cfg.DefaultPageSize, maxSize: cfg.MaxPageSize, key: key}
+}
+
+func (p *pager) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (p *pager) encode(c cursor) string {
+	data, _ := json.Marshal(c)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + p.sign(payload)
+}
+
+func (p *pager) decode(list, token string) (cursor, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(p.sign(payload))) {
+		return cursor{}, errBadCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return cursor{}, errBadCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.List != list {
+		return cursor{}, errBadCursor
+	}
+	return c, nil
+}
+
+// query makes the page query for a cursor of list, or the first page if it
+// is empty. size is the requested page size, 0 for the default; larger
+// sizes are cut down to the maximum.
+func (p *pager) query(list, token string, size int) (pageQuery, error) {
+	q := pageQuery{Limit: p.defaultSize}
+	if size > 0 {
+		q.Limit = min(size, p.maxSize)
+	}
+	if token == "" {
+		return q, nil
+	}
+	c, err := p.decode(list, token)
+	if err != nil {
+		return pageQuery{}, err
+	}
+	key := &pageKey{CreatedAt: c.CreatedAt, Id: c.Id}
+	if c.Backward {
+		q.Before = key
+	} else {
+		q.After = key
+	}
+	return q, nil
+}
+
+// pageCursors returns the cursors of the pages next to entries, read with q.
+// more says whether there were entries past them in the direction they were
+// read. A cursor is empty when there is no page in its direction.
+func pageCursors[T any](p *pager, list string, q pageQuery, entries []T, key func(T) pageKey, more bool) (next, prev string) {
+	if len(entries) == 0 {
+		return "", ""
+	}
+	first, last := key(entries[0]), key(entries[len(entries)-1])
+	after := p.encode(cursor{List: list, CreatedAt: last.CreatedAt, Id: last.Id})
+	before := p.encode(cursor{List: list, Backward: true, CreatedAt: first.CreatedAt, Id: first.Id})
+	if q.Before != nil {
+		next = after
+		if more {
+			prev = before
+		}
+		return next, prev
+	}
+	if more {
+		next = after
+	}
+	if q.After != nil {
+		prev = before
+	}
+	return next, prev
+}
+
+// pageQueryFromRequest reads ?cursor= and ?limit= for list. On failure it
+// has already written the response.
+func (s *server) pageQueryFromRequest(c *gin.Context, list string) (pageQuery, bool) {
+	var size int
+	if limit := c.Query("limit"); limit != "" {
+		var err error
+		if size, err = strconv.Atoi(limit); err != nil || size < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return pageQuery{}, false
+		}
+	}
+	q, err := s.pages.query(list, c.Query("cursor"), size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return pageQuery{}, false
+	}
+	return q, true
+}
+
+// setPageLinks links the next and previous pages in an RFC 8288 Link header,
+// for clients of the legacy JSON lists, which have nowhere else to put them.
+// The links keep the other query parameters of the request.
+func setPageLinks(c *gin.Context, next, prev string) {
+	var links []string
+	for _, link := range []struct{ rel, cursor string }{{"next", next}, {"prev", prev}} {
+		if link.cursor == "" {
+			continue
+		}
+		query := c.Request.URL.Query()
+		query.Set("cursor", link.cursor)
+		query.Del("access_token")
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, c.Request.URL.Path, query.Encode(), link.rel))
+	}
+	if len(links) > 0 {
+		c.Header("Link", strings.Join(links, ", "))
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes of service accounts, whose tokens needn't belong to a user.
+const (
+	// scopeActivitiesWriteAny lets a caller create activities on anyone's
+	// behalf, as the activity processor does.
+	scopeActivitiesWriteAny = "activities:write:any"
+	// scopeActivitiesReadAny lets a caller read private activities.
+	scopeActivitiesReadAny = "activities:read:any"
+	// scopeAdmin lets a caller manage users and the service.
+	scopeAdmin = "admin"
+)
+
+var serviceScopes = []string{scopeActivitiesWriteAny, scopeActivitiesReadAny, scopeAdmin}
+
+// hasScope reports whether the claims grant scope. It is false for anonymous
+// callers, whose claims are nil.
+func (c *authClaims) hasScope(scope string) bool {
+	return c != nil && slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+// accessRequest is what a policy decides on: who is asking, and about what.
+type accessRequest struct {
+	// caller is the user the token was issued to, nil for anonymous callers
+	// and service accounts. claims are set for every authenticated token,
+	// service accounts' included, and are nil only for anonymous callers.
+	caller *proto.User
+	claims *authClaims
+	// owner is the user the resource belongs to, if any and if known.
+	owner *proto.User
+	// activity is the activity being written, if any.
+	activity *proto.UserActivity
+}
+
+// rule is one way of being allowed to do something.
+type rule struct {
+	name  string
+	allow func(accessRequest) bool
+}
+
+// policy says who may take an action: a request is allowed when any of the
+// rules allows it. denial is what callers who aren't are told.
+type policy struct {
+	action string
+	denial string
+	rules  []rule
+}
+
+func scopeRule(scope string) rule {
+	return rule{name: "scope " + scope, allow: func(r accessRequest) bool { return r.claims.hasScope(scope) }}
+}
+
+var (
+	ruleAdmin = scopeRule(scopeAdmin)
+
+	// ruleOwner allows the user the resource belongs to.
+	ruleOwner = rule{name: "owner", allow: func(r accessRequest) bool {
+		return r.caller != nil && r.owner != nil && r.caller.Id == r.owner.Id
+	}}
+
+	// rulePublicActivities allows anyone to read the activities of users
+	// who haven't made them private, or who don't exist.
+	rulePublicActivities = rule{name: "public", allow: func(r accessRequest) bool {
+		return r.owner == nil || r.owner.ActivityVisibility == proto.ActivityVisibility_PUBLIC
+	}}
+
+	// ruleSubject allows users to write activities they are a subject of.
+	// Only USER subjects count: the owner of anything else is whatever the
+	// client says it is.
+	ruleSubject = rule{name: "subject", allow: func(r accessRequest) bool {
+		if r.caller == nil || r.activity == nil {
+			return false
+		}
+		for _, referring := range r.activity.SubjectReferring {
+			if referring.Type == proto.ReferringType_USER && referring.Id == r.caller.Id {
+				return true
+			}
+		}
+		return false
+	}}
+)
+
+// The policies of the REST and WebSocket endpoints.
+var (
+	policyManageLogging = policy{
+		action: "logging.manage",
+		denial: "managing logging takes the " + scopeAdmin + " scope",
+		rules:  []rule{ruleAdmin},
+	}
+	policyCreateUser = policy{
+		action: "user.create",
+		denial: "creating users takes the " + scopeAdmin + " scope",
+		rules:  []rule{ruleAdmin},
+	}
+	policyUpdateUser = policy{
+		action: "user.update",
+		denial: "users can only be changed by themselves",
+		rules:  []rule{ruleOwner, ruleAdmin},
+	}
+	policyDeleteUser = policy{
+		action: "user.delete",
+		denial: "users can only be deleted by themselves",
+		rules:  []rule{ruleOwner, ruleAdmin},
+	}
+	policyHeartbeat = policy{
+		action: "user.heartbeat",
+		denial: "heartbeats can only be sent for yourself",
+		rules:  []rule{ruleOwner, ruleAdmin},
+	}
+	policyCreateActivity = policy{
+		action: "activity.create",
+		denial: "activities can only be created by one of their subjects",
+		rules:  []rule{ruleSubject, scopeRule(scopeActivitiesWriteAny)},
+	}
+	policyReadUserActivities = policy{
+		action: "user.activities.read",
+		denial: "the user's activities are private",
+		rules:  []rule{rulePublicActivities, ruleOwner, scopeRule(scopeActivitiesReadAny), ruleAdmin},
+	}
+)
+
+// decide returns whether p allows r, and by which rule.
+func (p policy) decide(r accessRequest) (allowed bool, by string) {
+	for _, rule := range p.rules {
+		if rule.allow(r) {
+			return true, rule.name
+		}
+	}
+	return false, ""
+}
+
+// allow decides r under p and records the decision in the audit log. An
+// insecure server allows everything, by the "insecure" rule.
+func (s *server) allow(ctx context.Context, p policy, r accessRequest) bool {
+	allowed, by := p.decide(r)
+	if s.insecure {
+		allowed, by = true, "insecure"
+	}
+
+	attrs := []slog.Attr{
+		slog.String("action", p.action),
+		slog.Bool("allowed", allowed),
+	}
+	if allowed {
+		attrs = append(attrs, slog.String("rule", by))
+	}
+	if r.claims != nil {
+		attrs = append(attrs, slog.String("subject", r.claims.Subject), slog.String("scope", r.claims.Scope))
+	}
+	if r.owner != nil {
+		attrs = append(attrs, slog.String("owner", r.owner.Id))
+	}
+	if r.activity != nil {
+		attrs = append(attrs, slog.String("feed_id", r.activity.FeedId))
+	}
+	s.audit.LogAttrs(ctx, slog.LevelInfo, "Authorization decision", attrs...)
+	return allowed
+}
+
+// authorize decides r for the caller of the request. If it isn't allowed,
+// it answers 401 to anonymous callers, who might be allowed once signed in,
+// and 403 to everyone else.
+func (s *server) authorize(c *gin.Context, p policy, r accessRequest) bool {
+	r.caller, r.claims = callerFromContext(c), claimsFromContext(c)
+	if s.allow(c.Request.Context(), p, r) {
+		return true
+	}
+	if r.claims == nil {
+		unauthorized(c, "", "authentication is required: "+p.denial)
+		return false
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": p.denial, "action": p.action})
+	return false
+}
+
+// restrictToReadable narrows f to the activities the caller may read:
+// policyReadUserActivities has to allow each user an activity involves, so
+// unless it allows the caller any private user, activities involving private
+// users other than the caller are left out. Lists leave them out rather than
+// refuse, so nothing is audited.
+func (s *server) restrictToReadable(f *activityFilter, caller *proto.User, claims *authClaims) {
+	if s.insecure {
+		return
+	}
+	private := &proto.User{ActivityVisibility: proto.ActivityVisibility_PRIVATE}
+	if allowed, _ := policyReadUserActivities.decide(accessRequest{caller: caller, claims: claims, owner: private}); allowed {
+		return
+	}
+	f.HidePrivate = true
+	if caller != nil {
+		f.Reader = caller.Id
+	}
+}
+
+// readableActivity is restrictToReadable for a single activity, such as one
+// being pushed to a stream.
+func (s *server) readableActivity(ctx context.Context, activity *proto.UserActivity, caller *proto.User, claims *authClaims) (bool, error) {
+	var f activityFilter
+	s.restrictToReadable(&f, caller, claims)
+	if !f.HidePrivate {
+		return true, nil
+	}
+	owners, err := s.users.GetUsers(ctx, activityOwnerIds(activity))
+	if err != nil {
+		return false, err
+	}
+	return !hiddenByOwners(f, owners), nil
+}
+
+// activityOwnerIds returns the ids of the users the referrings of activity
+// belong to.
+func activityOwnerIds(activity *proto.UserActivity) []string {
+	var ids []string
+	for _, referrings := range [][]*proto.UserActivityReferring{activity.SubjectReferring, activity.ObjectReferring} {
+		for _, referring := range referrings {
+			if id := referringUserId(referring); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// hiddenByOwners reports whether f's HidePrivate leaves out an activity
+// involving owners.
+func hiddenByOwners(f activityFilter, owners []*proto.User) bool {
+	return f.HidePrivate && slices.ContainsFunc(owners, func(owner *proto.User) bool {
+		return owner.ActivityVisibility == proto.ActivityVisibility_PRIVATE && owner.Id != f.Reader
+	})
+}
+
+// require returns middleware that authorizes p for the user named by the id
+// parameter, for policies that need nothing more.
+func (s *server) require(p policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var owner *proto.User
+		if id := c.Param("id"); id != "" {
+			owner = &proto.User{Id: id}
+		}
+		if s.authorize(c, p, accessRequest{owner: owner}) {
+			c.Next()
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// presenceTracker takes heartbeats and coalesces them into periodic batched
+// last_seen writes. Until a heartbeat is flushed it is still reflected in the
+// users served, so presence is live even though the database lags behind.
+type presenceTracker struct {
+	users         UserStore
+	onlineWithin  time.Duration
+	awayWithin    time.Duration
+	flushInterval time.Duration
+
+	mu sync.Mutex
+	// seen holds the latest heartbeat of every user heard from recently;
+	// pending is the subset not yet written to the store.
+	seen    map[string]time.Time
+	pending map[string]time.Time
+	// states is the presence last reported to watchers for users in seen.
+	states   map[string]proto.Presence
+	watchers map[chan presenceChange]struct{}
+}
+
+// presenceChange is a tracked user going online, away or offline.
+type presenceChange struct {
+	UserId   string
+	Presence proto.Presence
+	LastSeen string
+}
+
+func newPresenceTracker(users UserStore, onlineWithin, awayWithin, flushInterval time.Duration) *presenceTracker {
+	return &presenceTracker{
+		users:         users,
+		onlineWithin:  onlineWithin,
+		awayWithin:    awayWithin,
+		flushInterval: flushInterval,
+		seen:          make(map[string]time.Time),
+		pending:       make(map[string]time.Time),
+		states:        make(map[string]proto.Presence),
+		watchers:      make(map[chan presenceChange]struct{}),
+	}
+}
+
+// Heartbeat records that the user is around. Users are looked up only when
+// they aren't already being tracked, so steady pings never hit the store.
+func (p *presenceTracker) Heartbeat(ctx context.Context, userId string, at time.Time) error {
+	p.mu.Lock()
+	_, known := p.seen[userId]
+	p.mu.Unlock()
+	if !known {
+		if _, err := p.users.GetUser(ctx, userId); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if at.After(p.seen[userId]) {
+		p.seen[userId] = at
+		p.pending[userId] = at
+		p.report(userId, p.presenceAt(at, time.Now()), at)
+	}
+	return nil
+}
+
+// Run flushes pending heartbeats every flushInterval until ctx is done, then
+// flushes once more. Users going away or offline are noticed on each flush.
+func (p *presenceTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := p.flush(flushCtx); err != nil {
+				slog.Error("Failed to flush heartbeats", "error", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := p.flush(ctx); err != nil {
+				slog.Error("Failed to flush heartbeats", "error", err)
+			}
+		}
+	}
+}
+
+func (p *presenceTracker) flush(ctx context.Context) error {
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = make(map[string]time.Time)
+	// Users quiet for longer than the away threshold are offline whatever
+	// the store says, so there's no need to keep overriding it.
+	now := time.Now()
+	for userId, at := range p.seen {
+		presence := p.presenceAt(at, now)
+		p.report(userId, presence, at)
+		if presence == proto.Presence_OFFLINE {
+			delete(p.seen, userId)
+			delete(p.states, userId)
+		}
+	}
+	p.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := p.users.TouchUsers(ctx, pending); err != nil {
+		// Put them back for the next round unless newer ones came in.
+		p.mu.Lock()
+		for userId, at := range pending {
+			if at.After(p.pending[userId]) {
+				p.pending[userId] = at
+			}
+		}
+		p.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// apply brings user.LastSeen up to date with unflushed heartbeats and sets
+// user.Presence from it.
+func (p *presenceTracker) apply(user *proto.User, now time.Time) {
+	lastSeen, _ := time.Parse(lastSeenLayout, user.LastSeen)
+
+	p.mu.Lock()
+	if at := p.seen[user.Id]; at.After(lastSeen) {
+		lastSeen = at
+		user.LastSeen = at.UTC().Format(lastSeenLayout)
+	}
+	p.mu.Unlock()
+
+	user.Presence = p.presenceAt(lastSeen, now)
+}
+
+func (p *presenceTracker) presenceAt(lastSeen, now time.Time) proto.Presence {
+	switch since := now.Sub(lastSeen); {
+	case lastSeen.IsZero():
+		return proto.Presence_OFFLINE
+	case since <= p.onlineWithin:
+		return proto.Presence_ONLINE
+	case since <= p.awayWithin:
+		return proto.Presence_AWAY
+	default:
+		return proto.Presence_OFFLINE
+	}
+}
+
+// Watch delivers presence changes until stop is called. Like an activity
+// subscription, changes is closed if the watcher falls behind; current
+// presence then has to be fetched again.
+func (p *presenceTracker) Watch(bufferSize int) (changes <-chan presenceChange, stop func()) {
+	c := make(chan presenceChange, bufferSize)
+	p.mu.Lock()
+	p.watchers[c] = struct{}{}
+	p.mu.Unlock()
+
+	return c, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, ok := p.watchers[c]; ok {
+			delete(p.watchers, c)
+			close(c)
+		}
+	}
+}
+
+// report tells watchers about a user's presence if it changed. p.mu must be
+// held.
+func (p *presenceTracker) report(userId string, presence proto.Presence, lastSeen time.Time) {
+	if p.states[userId] == presence {
+		return
+	}
+	p.states[userId] = presence
+
+	change := presenceChange{UserId: userId, Presence: presence, LastSeen: lastSeen.UTC().Format(lastSeenLayout)}
+	for c := range p.watchers {
+		select {
+		case c <- change:
+		default:
+			delete(p.watchers, c)
+			close(c)
+		}
+	}
+}
+
+// seenSince returns the ids of the users who sent a heartbeat at or after
+// since, including those not yet flushed to the store.
+func (p *presenceTracker) seenSince(since time.Time) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ids []string
+	for id, at := range p.seen {
+		if !at.Before(since) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (p *presenceTracker) applyAll(users []*proto.User) {
+	now := time.Now()
+	for _, user := range users {
+		p.apply(user, now)
+	}
+}
+
+func (s *server) postHeartbeat(c *gin.Context) {
+	err := s.presence.Heartbeat(c.Request.Context(), c.Param("id"), time.Now())
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getOnlineUsers lists users who are online, or with ?away=true also those
+// who are away. They are the users the store last saw recently enough, and
+// those whose heartbeats haven't been flushed to it yet.
+func (s *server) getOnlineUsers(c *gin.Context) {
+	ctx := c.Request.Context()
+	includeAway := c.Query("away") == "true"
+	within := s.presence.onlineWithin
+	if includeAway {
+		within = s.presence.awayWithin
+	}
+	// last_seen is kept to the second.
+	since := time.Now().Add(-within).Truncate(time.Second)
+
+	users, err := s.users.ListUsersSeenSince(ctx, since)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	listed := make(map[string]bool, len(users))
+	for _, user := range users {
+		listed[user.Id] = true
+	}
+	var unflushed []string
+	for _, id := range s.presence.seenSince(since) {
+		if !listed[id] {
+			unflushed = append(unflushed, id)
+		}
+	}
+	if len(unflushed) > 0 {
+		more, err := s.users.GetUsers(ctx, unflushed)
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		users = append(users, more...)
+		slices.SortFunc(users, func(a, b *proto.User) int { return strings.Compare(a.Id, b.Id) })
+	}
+	s.presence.applyAll(users)
+
+	var online []*proto.User
+	for _, user := range users {
+		if user.Presence == proto.Presence_ONLINE || (includeAway && user.Presence == proto.Presence_AWAY) {
+			online = append(online, user)
+		}
+	}
+	s.respondUsers(c, &proto.ListUsersResponse{Users: online})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+)
+
+func TestGetOnlineUsers(t *testing.T) {
+	ts := newTestServer(t)
+	// Charlie was last seen ten minutes ago, as the store has it.
+	ts.store.putUser(&proto.User{Id: "3", Name: "Charlie", LastSeen: time.Now().Add(-10 * time.Minute).UTC().Format(lastSeenLayout)})
+	// Bob's heartbeat hasn't been flushed yet.
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/2/heartbeat", token(t, "2", ""), nil), http.StatusNoContent)
+
+	for query, want := range map[string]string{"": "2", "?away=true": "2,3"} {
+		w := ts.do(t, http.MethodGet, "/users/online"+query, "", nil)
+		expectStatus(t, w, http.StatusOK)
+		var ids []string
+		for _, user := range decode[[]map[string]any](t, w) {
+			ids = append(ids, user["id"].(string))
+		}
+		if got := strings.Join(ids, ","); got != want {
+			t.Errorf("/users/online%s = %s, want %s", query, got, want)
+		}
+	}
+}
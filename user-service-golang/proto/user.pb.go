@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.11
+// 	protoc-gen-go v1.36.9
 // 	protoc        v6.33.5
 // source: user.proto
 
@@ -21,13 +21,110 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type Presence int32
+
+const (
+	Presence_OFFLINE Presence = 0
+	Presence_AWAY    Presence = 1
+	Presence_ONLINE  Presence = 2
+)
+
+// Enum value maps for Presence.
+var (
+	Presence_name = map[int32]string{
+		0: "OFFLINE",
+		1: "AWAY",
+		2: "ONLINE",
+	}
+	Presence_value = map[string]int32{
+		"OFFLINE": 0,
+		"AWAY":    1,
+		"ONLINE":  2,
+	}
+)
+
+func (x Presence) Enum() *Presence {
+	p := new(Presence)
+	*p = x
+	return p
+}
+
+func (x Presence) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Presence) Descriptor() protoreflect.EnumDescriptor {
+	return file_user_proto_enumTypes[0].Descriptor()
+}
+
+func (Presence) Type() protoreflect.EnumType {
+	return &file_user_proto_enumTypes[0]
+}
+
+func (x Presence) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Presence.Descriptor instead.
+func (Presence) EnumDescriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{0}
+}
+
+type ActivityVisibility int32
+
+const (
+	ActivityVisibility_PUBLIC  ActivityVisibility = 0
+	ActivityVisibility_PRIVATE ActivityVisibility = 1
+)
+
+// Enum value maps for ActivityVisibility.
+var (
+	ActivityVisibility_name = map[int32]string{
+		0: "PUBLIC",
+		1: "PRIVATE",
+	}
+	ActivityVisibility_value = map[string]int32{
+		"PUBLIC":  0,
+		"PRIVATE": 1,
+	}
+)
+
+func (x ActivityVisibility) Enum() *ActivityVisibility {
+	p := new(ActivityVisibility)
+	*p = x
+	return p
+}
+
+func (x ActivityVisibility) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ActivityVisibility) Descriptor() protoreflect.EnumDescriptor {
+	return file_user_proto_enumTypes[1].Descriptor()
+}
+
+func (ActivityVisibility) Type() protoreflect.EnumType {
+	return &file_user_proto_enumTypes[1]
+}
+
+func (x ActivityVisibility) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ActivityVisibility.Descriptor instead.
+func (ActivityVisibility) EnumDescriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{1}
+}
+
 type User struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
-	LastSeen      string                 `protobuf:"bytes,3,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Id                 string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name               string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	LastSeen           string                 `protobuf:"bytes,3,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
+	Presence           Presence               `protobuf:"varint,4,opt,name=presence,proto3,enum=com.test.charles.shared.models.Presence" json:"presence,omitempty"`
+	ActivityVisibility ActivityVisibility     `protobuf:"varint,5,opt,name=activity_visibility,json=activityVisibility,proto3,enum=com.test.charles.shared.models.ActivityVisibility" json:"activity_visibility,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
 }
 
 func (x *User) Reset() {
@@ -81,16 +178,41 @@ func (x *User) GetLastSeen() string {
 	return ""
 }
 
+func (x *User) GetPresence() Presence {
+	if x != nil {
+		return x.Presence
+	}
+	return Presence_OFFLINE
+}
+
+func (x *User) GetActivityVisibility() ActivityVisibility {
+	if x != nil {
+		return x.ActivityVisibility
+	}
+	return ActivityVisibility_PUBLIC
+}
+
 var File_user_proto protoreflect.FileDescriptor
 
 const file_user_proto_rawDesc = "" +
 	"\n" +
 	"\n" +
-	"user.proto\x12\x1ecom.test.charles.shared.models\"G\n" +
+	"user.proto\x12\x1ecom.test.charles.shared.models\"\xf2\x01\n" +
 	"\x04User\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
 	"\x04name\x18\x02 \x01(\tR\x04name\x12\x1b\n" +
-	"\tlast_seen\x18\x03 \x01(\tR\blastSeenBi\n" +
+	"\tlast_seen\x18\x03 \x01(\tR\blastSeen\x12D\n" +
+	"\bpresence\x18\x04 \x01(\x0e2(.com.test.charles.shared.models.PresenceR\bpresence\x12c\n" +
+	"\x13activity_visibility\x18\x05 \x01(\x0e22.com.test.charles.shared.models.ActivityVisibilityR\x12activityVisibility*-\n" +
+	"\bPresence\x12\v\n" +
+	"\aOFFLINE\x10\x00\x12\b\n" +
+	"\x04AWAY\x10\x01\x12\n" +
+	"\n" +
+	"\x06ONLINE\x10\x02*-\n" +
+	"\x12ActivityVisibility\x12\n" +
+	"\n" +
+	"\x06PUBLIC\x10\x00\x12\v\n" +
+	"\aPRIVATE\x10\x01Bi\n" +
 	"\x1ecom.test.charles.shared.modelsB\tUserProtoP\x01Z:charles/career-break-learn/user-service-golang/proto;protob\x06proto3"
 
 var (
@@ -105,16 +227,21 @@ func file_user_proto_rawDescGZIP() []byte {
 	return file_user_proto_rawDescData
 }
 
+var file_user_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
 var file_user_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
 var file_user_proto_goTypes = []any{
-	(*User)(nil), // 0: com.test.charles.shared.models.User
+	(Presence)(0),           // 0: com.test.charles.shared.models.Presence
+	(ActivityVisibility)(0), // 1: com.test.charles.shared.models.ActivityVisibility
+	(*User)(nil),            // 2: com.test.charles.shared.models.User
 }
 var file_user_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0, // 0: com.test.charles.shared.models.User.presence:type_name -> com.test.charles.shared.models.Presence
+	1, // 1: com.test.charles.shared.models.User.activity_visibility:type_name -> com.test.charles.shared.models.ActivityVisibility
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_user_proto_init() }
@@ -127,13 +254,14 @@ func file_user_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_user_proto_rawDesc), len(file_user_proto_rawDesc)),
-			NumEnums:      0,
+			NumEnums:      2,
 			NumMessages:   1,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_user_proto_goTypes,
 		DependencyIndexes: file_user_proto_depIdxs,
+		EnumInfos:         file_user_proto_enumTypes,
 		MessageInfos:      file_user_proto_msgTypes,
 	}.Build()
 	File_user_proto = out.File
@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.11
+// 	protoc-gen-go v1.36.9
 // 	protoc        v6.33.5
 // source: user_activity.proto
 
@@ -27,6 +27,8 @@ type UserActivity struct {
 	SubjectReferring   []*UserActivityReferring `protobuf:"bytes,2,rep,name=subject_referring,json=subjectReferring,proto3" json:"subject_referring,omitempty"`
 	ObjectReferring    []*UserActivityReferring `protobuf:"bytes,3,rep,name=object_referring,json=objectReferring,proto3" json:"object_referring,omitempty"`
 	ActionTextTemplate string                   `protobuf:"bytes,4,opt,name=action_text_template,json=actionTextTemplate,proto3" json:"action_text_template,omitempty"`
+	CreatedAt          string                   `protobuf:"bytes,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	FeedIds            []string                 `protobuf:"bytes,6,rep,name=feed_ids,json=feedIds,proto3" json:"feed_ids,omitempty"`
 	unknownFields      protoimpl.UnknownFields
 	sizeCache          protoimpl.SizeCache
 }
@@ -89,16 +91,33 @@ func (x *UserActivity) GetActionTextTemplate() string {
 	return ""
 }
 
+func (x *UserActivity) GetCreatedAt() string {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return ""
+}
+
+func (x *UserActivity) GetFeedIds() []string {
+	if x != nil {
+		return x.FeedIds
+	}
+	return nil
+}
+
 var File_user_activity_proto protoreflect.FileDescriptor
 
 const file_user_activity_proto_rawDesc = "" +
 	"\n" +
-	"\x13user_activity.proto\x12\x1ecom.test.charles.shared.models\x1a\x1duser_activity_referring.proto\"\x9f\x02\n" +
+	"\x13user_activity.proto\x12\x1ecom.test.charles.shared.models\x1a\x1duser_activity_referring.proto\"\xd9\x02\n" +
 	"\fUserActivity\x12\x17\n" +
 	"\afeed_id\x18\x01 \x01(\tR\x06feedId\x12b\n" +
 	"\x11subject_referring\x18\x02 \x03(\v25.com.test.charles.shared.models.UserActivityReferringR\x10subjectReferring\x12`\n" +
 	"\x10object_referring\x18\x03 \x03(\v25.com.test.charles.shared.models.UserActivityReferringR\x0fobjectReferring\x120\n" +
-	"\x14action_text_template\x18\x04 \x01(\tR\x12actionTextTemplateBq\n" +
+	"\x14action_text_template\x18\x04 \x01(\tR\x12actionTextTemplate\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\x05 \x01(\tR\tcreatedAt\x12\x19\n" +
+	"\bfeed_ids\x18\x06 \x03(\tR\afeedIdsBq\n" +
 	"\x1ecom.test.charles.shared.modelsB\x11UserActivityProtoP\x01Z:charles/career-break-learn/user-service-golang/proto;protob\x06proto3"
 
 var (
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ActivityPublisher sends accepted activities on to the stream processor.
+type ActivityPublisher interface {
+	Publish(ctx context.Context, activity *proto.UserActivity) error
+	Close() error
+}
+
+// activityMessage is an activity as it goes on the wire: protojson, keyed by
+// the aggregator's similarity key. Headers carry the trace context, so the
+// aggregator can continue the trace.
+type activityMessage struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+}
+
+func encodeActivityMessage(ctx context.Context, topic string, activity *proto.UserActivity) (activityMessage, error) {
+	value, err := jsonMarshaler.Marshal(activity)
+	if err != nil {
+		return activityMessage{}, err
+	}
+	headers := make(map[string]string)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(headers))
+	return activityMessage{
+		Topic:   topic,
+		Key:     []byte(similarityKey(activity)),
+		Value:   value,
+		Headers: headers,
+	}, nil
+}
+
+// similarityKey matches UserActivitiesAggregator.generateSimilarityKey in the
+// Java processor: the sorted TYPE#id of each subject joined by "-", then ":"
+// and the action text template.
+func similarityKey(activity *proto.UserActivity) string {
+	ids := make([]string, len(activity.SubjectReferring))
+	for i, referring := range activity.SubjectReferring {
+		ids[i] = referring.Type.String() + "#" + referring.Id
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, "-") + ":" + activity.ActionTextTemplate
+}
+
+type kafkaPublisher struct {
+	topic  string
+	writer *kafka.Writer
+}
+
+// newKafkaPublisher writes to topic on brokers. Murmur2 partitioning matches
+// the Java client's default partitioner, so equal keys land on the same
+// partition whichever side produced them.
+func newKafkaPublisher(brokers []string, topic string) *kafkaPublisher {
+	return &kafkaPublisher{
+		topic: topic,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Murmur2Balancer{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, activity *proto.UserActivity) (err error) {
+	ctx, span := tracer.Start(ctx, "send "+p.topic, trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		semconv.MessagingSystemKafka,
+		semconv.MessagingOperationTypeSend,
+		semconv.MessagingDestinationName(p.topic),
+	))
+	defer func() { endSpan(span, err) }()
+
+	msg, err := encodeActivityMessage(ctx, p.topic, activity)
+	if err != nil {
+		return err
+	}
+	headers := make([]kafka.Header, 0, len(msg.Headers))
+	for key, value := range msg.Headers {
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+	return p.writer.WriteMessages(ctx, kafka.Message{Key: msg.Key, Value: msg.Value, Headers: headers})
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// memoryPublisher stands in for Kafka by keeping the most recent messages.
+type memoryPublisher struct {
+	topic string
+	limit int
+
+	mu       sync.Mutex
+	messages []activityMessage
+}
+
+func newMemoryPublisher(topic string, limit int) *memoryPublisher {
+	return &memoryPublisher{topic: topic, limit: limit}
+}
+
+func (p *memoryPublisher) Publish(ctx context.Context, activity *proto.UserActivity) error {
+	msg, err := encodeActivityMessage(ctx, p.topic, activity)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, msg)
+	if len(p.messages) > p.limit {
+		p.messages = p.messages[len(p.messages)-p.limit:]
+	}
+	return nil
+}
+
+// Messages returns what has been published, oldest first.
+func (p *memoryPublisher) Messages() []activityMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]activityMessage(nil), p.messages...)
+}
+
+func (p *memoryPublisher) Close() error {
+	return nil
+}
+
+// pingKafka returns a readiness check that passes when any of the brokers
+// accepts a connection.
+func pingKafka(brokers []string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		var err error
+		for _, broker := range brokers {
+			var conn *kafka.Conn
+			if conn, err = kafka.DialContext(ctx, "tcp", broker); err == nil {
+				return conn.Close()
+			}
+		}
+		return err
+	}
+}
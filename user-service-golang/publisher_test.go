@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMemoryPublisher(t *testing.T) {
+	p := newMemoryPublisher("user-activities", 2)
+	for i := range 3 {
+		err := p.Publish(context.Background(), &proto.UserActivity{
+			FeedId:             fmt.Sprint("feed", i),
+			ActionTextTemplate: "{subject} waved.",
+			SubjectReferring:   []*proto.UserActivityReferring{{Type: proto.ReferringType_USER, Id: "2"}, {Type: proto.ReferringType_USER, Id: "1"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := p.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("kept %d messages, want the last 2", len(messages))
+	}
+	msg := messages[1]
+	if msg.Topic != "user-activities" {
+		t.Errorf("topic = %q", msg.Topic)
+	}
+	// The key is the Java aggregator's similarity key.
+	if want := "USER#1-USER#2:{subject} waved."; string(msg.Key) != want {
+		t.Errorf("key = %q, want %q", msg.Key, want)
+	}
+	activity, err := decodeAggregatedActivity(sourceMessage{Key: msg.Key, Value: msg.Value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activity.FeedId != "feed2" || len(activity.SubjectReferring) != 2 {
+		t.Errorf("value decodes to %v", activity)
+	}
+}
+
+func TestMemoryPublisherTraceContext(t *testing.T) {
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	p := newMemoryPublisher("user-activities", 10)
+	if err := p.Publish(ctx, &proto.UserActivity{FeedId: "feed1"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "00-01000000000000000000000000000000-0200000000000000-01"; p.Messages()[0].Headers["traceparent"] != want {
+		t.Fatalf("headers = %v, want traceparent %s", p.Messages()[0].Headers, want)
+	}
+}
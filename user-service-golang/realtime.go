@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// The realtime channel at GET /ws speaks JSON messages told apart by "type".
+// The client sends:
+//
+//	{"type":"subscribe","id":"1","topic":"activities","userId":"2","lastEventId":"..."}
+//	{"type":"unsubscribe","id":"2","topic":"presence","userId":"2"}
+//	{"type":"heartbeat"}
+//	{"type":"ack","seq":17}
+//
+// and receives:
+//
+//	{"type":"ack","id":"1"}
+//	{"type":"error","id":"1","message":"user not found"}
+//	{"type":"activity","topic":"activities","userId":"2","seq":17,"eventId":"...","activity":{...}}
+//	{"type":"reset","topic":"activities","userId":"2"}
+//	{"type":"presence","topic":"presence","userId":"2","presence":"ONLINE","lastSeen":"..."}
+//
+// Commands carrying an id are answered with an ack or an error. The
+// "activities" topic is the user's feed as in GET /users/:id/feed, rendered
+// for ?viewer=. The "presence" topic sends the user's presence, then every
+// change to it, whichever instance the user sends heartbeats to. Heartbeats
+// are sent for the user the connection authenticated as, or for ?viewer= when
+// the server is insecure.
+//
+// Activities are numbered by seq and the client acks them cumulatively; once
+// wsMaxUnacked are outstanding, delivery pauses until it does.
+const (
+	wsMessageSubscribe   = "subscribe"
+	wsMessageUnsubscribe = "unsubscribe"
+	wsMessageHeartbeat   = "heartbeat"
+	wsMessageAck         = "ack"
+	wsMessageError       = "error"
+	wsMessageActivity    = "activity"
+	wsMessageReset       = "reset"
+	wsMessagePresence    = "presence"
+
+	wsTopicActivities = "activities"
+	wsTopicPresence   = "presence"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = wsPongWait * 9 / 10
+	wsMaxMessageSize = 4096
+	wsSendBuffer     = 64
+	wsMaxUnacked     = 256
+)
+
+type wsMessage struct {
+	Type        string         `json:"type"`
+	Id          string         `json:"id,omitempty"`
+	Topic       string         `json:"topic,omitempty"`
+	UserId      string         `json:"userId,omitempty"`
+	LastEventId string         `json:"lastEventId,omitempty"`
+	Seq         uint64         `json:"seq,omitempty"`
+	EventId     string         `json:"eventId,omitempty"`
+	Activity    map[string]any `json:"activity,omitempty"`
+	Presence    string         `json:"presence,omitempty"`
+	LastSeen    string         `json:"lastSeen,omitempty"`
+	Message     string         `json:"message,omitempty"`
+}
+
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// realtimeConn is one WebSocket session. Each subscription runs its own pump
+// goroutine feeding send, which a single writer drains, so a slow client
+// holds up only its own pumps; a pump that falls behind the broadcaster is
+// dropped and resumes from its last event id.
+type realtimeConn struct {
+	s      *server
+	conn   *websocket.Conn
+	viewer *feedViewer
+	// caller and claims are who authenticated the connection, if anyone.
+	caller *proto.User
+	claims *authClaims
+	send   chan wsMessage
+	pumps  sync.WaitGroup
+
+	mu   sync.Mutex
+	subs map[string]context.CancelFunc
+	// sent and acked count activities; ackCh is closed and replaced on
+	// every ack to wake pumps waiting for the window to open.
+	sent, acked uint64
+	ackCh       chan struct{}
+}
+
+func (s *server) getRealtime(c *gin.Context) {
+	v, ok := s.viewerFromQuery(c)
+	if !ok {
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade has already replied with an HTTP error.
+		return
+	}
+
+	rc := &realtimeConn{
+		s:      s,
+		conn:   conn,
+		viewer: v,
+		caller: callerFromContext(c),
+		claims: claimsFromContext(c),
+		send:   make(chan wsMessage, wsSendBuffer),
+		subs:   make(map[string]context.CancelFunc),
+		ackCh:  make(chan struct{}),
+	}
+	ctx, done := s.streamContext(c.Request.Context())
+	defer done()
+	rc.run(ctx)
+}
+
+// run serves the connection until either side closes it.
+func (rc *realtimeConn) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		// A writer that gave up leaves nothing to drain send, so stop
+		// whatever is waiting to queue a message.
+		defer cancel()
+		rc.writeLoop(ctx)
+	}()
+
+	err := rc.readLoop(ctx)
+	cancel()
+	rc.pumps.Wait()
+	<-writerDone
+
+	if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+		slog.WarnContext(ctx, "Realtime connection closed", "error", err)
+	}
+}
+
+// readLoop handles client messages until the connection fails or closes.
+func (rc *realtimeConn) readLoop(ctx context.Context) error {
+	rc.conn.SetReadLimit(wsMaxMessageSize)
+	rc.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	rc.conn.SetPongHandler(func(string) error {
+		return rc.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := rc.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			rc.enqueue(ctx, wsMessage{Type: wsMessageError, Message: "invalid message: " + err.Error()})
+			continue
+		}
+
+		err = rc.handle(ctx, msg)
+		switch {
+		case err != nil:
+			rc.enqueue(ctx, wsMessage{Type: wsMessageError, Id: msg.Id, Message: err.Error()})
+		case msg.Id != "":
+			rc.enqueue(ctx, wsMessage{Type: wsMessageAck, Id: msg.Id})
+		}
+	}
+}
+
+// writeLoop writes queued messages and pings until ctx is done or a write
+// fails, then closes the connection, which also ends readLoop.
+func (rc *realtimeConn) writeLoop(ctx context.Context) {
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	defer rc.conn.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			rc.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteWait))
+			return
+		case msg := <-rc.send:
+			rc.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := rc.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := rc.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (rc *realtimeConn) handle(ctx context.Context, msg wsMessage) error {
+	switch msg.Type {
+	case wsMessageSubscribe:
+		return rc.subscribe(ctx, msg)
+	case wsMessageUnsubscribe:
+		return rc.unsubscribe(msg)
+	case wsMessageHeartbeat:
+		return rc.heartbeat(ctx)
+	case wsMessageAck:
+		return rc.ack(msg.Seq)
+	default:
+		return fmt.Errorf("unknown message type %q", msg.Type)
+	}
+}
+
+// heartbeat records that the caller is around. ?viewer= only picks whose
+// view activities are rendered in, so it is trusted for heartbeats only when
+// the server is insecure, as POST /users/:id/heartbeat then takes anyone's.
+func (rc *realtimeConn) heartbeat(ctx context.Context) error {
+	user := rc.caller
+	if rc.s.insecure {
+		user = rc.viewer.you
+	}
+	if user == nil {
+		return errors.New("heartbeats need authentication")
+	}
+	if !rc.s.allow(ctx, policyHeartbeat, accessRequest{caller: rc.caller, claims: rc.claims, owner: user}) {
+		return errors.New(policyHeartbeat.denial)
+	}
+	return rc.s.presence.Heartbeat(ctx, user.Id, time.Now())
+}
+
+func (rc *realtimeConn) subscribe(ctx context.Context, msg wsMessage) error {
+	if msg.Topic != wsTopicActivities && msg.Topic != wsTopicPresence {
+		return fmt.Errorf("unknown topic %q", msg.Topic)
+	}
+	if msg.UserId == "" {
+		return errors.New("userId is required")
+	}
+	user, err := rc.s.users.GetUser(ctx, msg.UserId)
+	if errors.Is(err, ErrNotFound) {
+		return errors.New("user not found")
+	}
+	if err != nil {
+		return err
+	}
+	if msg.Topic == wsTopicActivities &&
+		!rc.s.allow(ctx, policyReadUserActivities, accessRequest{caller: rc.caller, claims: rc.claims, owner: user}) {
+		return errors.New(policyReadUserActivities.denial)
+	}
+
+	key := msg.Topic + ":" + msg.UserId
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if _, ok := rc.subs[key]; ok {
+		return nil
+	}
+	subCtx, cancel := context.WithCancel(ctx)
+	rc.subs[key] = cancel
+
+	rc.pumps.Add(1)
+	go func() {
+		defer rc.pumps.Done()
+		if msg.Topic == wsTopicActivities {
+			rc.pumpActivities(subCtx, msg.UserId, msg.LastEventId)
+		} else {
+			rc.pumpPresence(subCtx, msg.UserId)
+		}
+
+		// A pump that gave up by itself leaves the topic free to subscribe
+		// to again.
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		if subCtx.Err() == nil {
+			cancel()
+			delete(rc.subs, key)
+		}
+	}()
+	return nil
+}
+
+func (rc *realtimeConn) unsubscribe(msg wsMessage) error {
+	key := msg.Topic + ":" + msg.UserId
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	cancel, ok := rc.subs[key]
+	if !ok {
+		return fmt.Errorf("not subscribed to %s of %q", msg.Topic, msg.UserId)
+	}
+	cancel()
+	delete(rc.subs, key)
+	return nil
+}
+
+// ack acknowledges every activity up to and including seq.
+func (rc *realtimeConn) ack(seq uint64) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if seq > rc.sent {
+		return fmt.Errorf("seq %d has not been sent", seq)
+	}
+	if seq > rc.acked {
+		rc.acked = seq
+		close(rc.ackCh)
+		rc.ackCh = make(chan struct{})
+	}
+	return nil
+}
+
+// pumpActivities forwards the user's feed until ctx is done.
+func (rc *realtimeConn) pumpActivities(ctx context.Context, userId, lastEventId string) {
+	b := rc.s.broadcaster
+	filter := func(activity *proto.UserActivity) bool {
+		return involvesUser(activity, userId)
+	}
+	for {
+		sub, replay, resumed := b.Subscribe(lastEventId, filter)
+		if !resumed && !rc.enqueue(ctx, wsMessage{Type: wsMessageReset, Topic: wsTopicActivities, UserId: userId}) {
+			sub.Close()
+			return
+		}
+		for _, event := range replay {
+			if !rc.sendActivity(ctx, userId, event) {
+				sub.Close()
+				return
+			}
+			lastEventId = b.EventId(event)
+		}
+
+	forward:
+		for {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+				return
+			case event, ok := <-sub.C:
+				if !ok {
+					// Dropped for falling behind; pick up from here.
+					break forward
+				}
+				if !rc.sendActivity(ctx, userId, event) {
+					sub.Close()
+					return
+				}
+				lastEventId = b.EventId(event)
+			}
+		}
+	}
+}
+
+// sendActivity queues an activity once the ack window allows. Activities
+// the caller may not read are skipped.
+func (rc *realtimeConn) sendActivity(ctx context.Context, userId string, event activityEvent) bool {
+	readable, err := rc.s.readableActivity(ctx, event.Activity, rc.caller, rc.claims)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to check who may read an activity", "feed_id", event.Activity.FeedId, "error", err)
+	}
+	if !readable {
+		return true
+	}
+	var seq uint64
+	for seq == 0 {
+		rc.mu.Lock()
+		if rc.sent-rc.acked < wsMaxUnacked {
+			rc.sent++
+			seq = rc.sent
+		}
+		acked := rc.ackCh
+		rc.mu.Unlock()
+
+		if seq == 0 {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-acked:
+			}
+		}
+	}
+
+	if err := rc.viewer.load(ctx, event.Activity); err != nil {
+		slog.WarnContext(ctx, "Failed to load the users an activity names", "feed_id", event.Activity.FeedId, "error", err)
+	}
+	return rc.enqueue(ctx, wsMessage{
+		Type:     wsMessageActivity,
+		Topic:    wsTopicActivities,
+		UserId:   userId,
+		Seq:      seq,
+		EventId:  rc.s.broadcaster.EventId(event),
+		Activity: protoActivityToJSON(event.Activity, rc.viewer),
+	})
+}
+
+// pumpPresence sends the user's presence, then every change to it, until ctx
+// is done. Heartbeats this instance takes are reported as they come. Those
+// other instances take reach the store when they flush them, so the store is
+// read again as often as heartbeats are flushed, which also notices the user
+// going away or offline.
+func (rc *realtimeConn) pumpPresence(ctx context.Context, userId string) {
+	poll := time.NewTicker(rc.s.presence.flushInterval)
+	defer poll.Stop()
+	// Only changes of presence are sent, not every newer last seen.
+	var last proto.Presence
+	sent := false
+	send := func(change presenceChange) bool {
+		if sent && change.Presence == last {
+			return true
+		}
+		sent, last = true, change.Presence
+		return rc.enqueue(ctx, presenceMessage(change))
+	}
+
+	for {
+		// Watch before reading the current presence so no change falls in
+		// between.
+		changes, stop := rc.s.presence.Watch(wsSendBuffer)
+		current, err := rc.currentPresence(ctx, userId)
+		if err != nil {
+			stop()
+			if ctx.Err() == nil {
+				rc.enqueue(ctx, wsMessage{Type: wsMessageError, Topic: wsTopicPresence, UserId: userId, Message: err.Error()})
+			}
+			return
+		}
+		if !send(current) {
+			stop()
+			return
+		}
+
+	watch:
+		for {
+			select {
+			case <-ctx.Done():
+				stop()
+				return
+			case change, ok := <-changes:
+				if !ok {
+					// Dropped for falling behind; read the presence again.
+					break watch
+				}
+				if change.UserId == userId && !send(change) {
+					stop()
+					return
+				}
+			case <-poll.C:
+				current, err := rc.currentPresence(ctx, userId)
+				if err != nil {
+					slog.WarnContext(ctx, "Failed to read presence", "user_id", userId, "error", err)
+					continue
+				}
+				if !send(current) {
+					stop()
+					return
+				}
+			}
+		}
+		stop()
+	}
+}
+
+// currentPresence reads the user's presence from the store, brought up to
+// date with the heartbeats this instance hasn't flushed yet.
+func (rc *realtimeConn) currentPresence(ctx context.Context, userId string) (presenceChange, error) {
+	user, err := rc.s.users.GetUser(ctx, userId)
+	if err != nil {
+		return presenceChange{}, err
+	}
+	rc.s.presence.apply(user, time.Now())
+	return presenceChange{UserId: userId, Presence: user.Presence, LastSeen: user.LastSeen}, nil
+}
+
+func presenceMessage(change presenceChange) wsMessage {
+	return wsMessage{
+		Type:     wsMessagePresence,
+		Topic:    wsTopicPresence,
+		UserId:   change.UserId,
+		Presence: change.Presence.String(),
+		LastSeen: change.LastSeen,
+	}
+}
+
+// enqueue queues a message for the writer, blocking while the queue is full.
+func (rc *realtimeConn) enqueue(ctx context.Context, msg wsMessage) bool {
+	select {
+	case rc.send <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialRealtime opens /ws on ts with the given query.
+func dialRealtime(t *testing.T, ts *testServer, query string) *websocket.Conn {
+	t.Helper()
+	httpServer := httptest.NewServer(ts.router)
+	t.Cleanup(httpServer.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// command sends msg and returns the ack or error answering it.
+func command(t *testing.T, conn *websocket.Conn, msg wsMessage) wsMessage {
+	t.Helper()
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var reply wsMessage
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Id == msg.Id {
+			return reply
+		}
+	}
+}
+
+// seen reports whether ts has had a heartbeat from the user.
+func (ts *testServer) seen(userId string) bool {
+	ts.presence.mu.Lock()
+	defer ts.presence.mu.Unlock()
+	_, ok := ts.presence.seen[userId]
+	return ok
+}
+
+func TestRealtimeHeartbeat(t *testing.T) {
+	ts := newTestServer(t)
+
+	anonymous := dialRealtime(t, ts, "viewer=2")
+	if reply := command(t, anonymous, wsMessage{Type: wsMessageHeartbeat, Id: "1"}); reply.Type != wsMessageError {
+		t.Fatalf("anonymous heartbeat = %+v, want an error", reply)
+	}
+
+	// ?viewer= picks the view, not who is online.
+	alice := dialRealtime(t, ts, "viewer=2&access_token="+token(t, "1", ""))
+	if reply := command(t, alice, wsMessage{Type: wsMessageHeartbeat, Id: "1"}); reply.Type != wsMessageAck {
+		t.Fatalf("heartbeat = %+v, want an ack", reply)
+	}
+	if !ts.seen("1") || ts.seen("2") {
+		t.Fatalf("heartbeat recorded for the viewer rather than the caller")
+	}
+}
+
+// stallingListener accepts connections whose writes, once stall is set, hang
+// until release is closed and then fail, as they do when a client that never
+// reads lets the write deadline pass. stalled is closed when the first write
+// hangs.
+type stallingListener struct {
+	net.Listener
+	stall   atomic.Bool
+	release chan struct{}
+	stalled chan struct{}
+	once    sync.Once
+}
+
+func (l *stallingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &stallingConn{Conn: conn, l: l}, nil
+}
+
+type stallingConn struct {
+	net.Conn
+	l *stallingListener
+}
+
+func (c *stallingConn) Write(p []byte) (int, error) {
+	if c.l.stall.Load() {
+		c.l.once.Do(func() { close(c.l.stalled) })
+		<-c.l.release
+		return 0, os.ErrDeadlineExceeded
+	}
+	return c.Conn.Write(p)
+}
+
+func TestRealtimeWriteFailureEndsConnection(t *testing.T) {
+	ts := newTestServer(t)
+	l := &stallingListener{release: make(chan struct{}), stalled: make(chan struct{})}
+	httpServer := httptest.NewUnstartedServer(ts.router)
+	l.Listener = httpServer.Listener
+	httpServer.Listener = l
+	httpServer.Start()
+	t.Cleanup(httpServer.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The client never reads: the writer hangs on the first reply while the
+	// rest fill the queue, leaving the reader waiting on it.
+	l.stall.Store(true)
+	for i := range wsSendBuffer + 2 {
+		if err := conn.WriteJSON(wsMessage{Type: wsMessageHeartbeat, Id: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-l.stalled
+	time.Sleep(100 * time.Millisecond)
+	close(l.release)
+
+	closed := make(chan struct{})
+	go func() {
+		ts.streams.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection still served after its writer failed")
+	}
+}
+
+// realtimeClient reads the messages of a connection in the background, so
+// tests can wait for them, or for their absence, without a read deadline
+// breaking the connection.
+type realtimeClient struct {
+	conn *websocket.Conn
+	msgs chan wsMessage
+	// early holds messages read while waiting for a reply.
+	early []wsMessage
+}
+
+func connectRealtime(t *testing.T, ts *testServer, query string) *realtimeClient {
+	t.Helper()
+	c := &realtimeClient{conn: dialRealtime(t, ts, query), msgs: make(chan wsMessage, 1000)}
+	go func() {
+		defer close(c.msgs)
+		for {
+			var msg wsMessage
+			if err := c.conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+func (c *realtimeClient) send(t *testing.T, msg wsMessage) {
+	t.Helper()
+	if err := c.conn.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next returns the next message sent.
+func (c *realtimeClient) next(t *testing.T) wsMessage {
+	t.Helper()
+	if len(c.early) > 0 {
+		msg := c.early[0]
+		c.early = c.early[1:]
+		return msg
+	}
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message sent")
+		return wsMessage{}
+	}
+}
+
+// expectNone checks that nothing is sent for a while.
+func (c *realtimeClient) expectNone(t *testing.T) {
+	t.Helper()
+	if len(c.early) > 0 {
+		t.Fatalf("got %+v, want nothing", c.early[0])
+	}
+	select {
+	case msg := <-c.msgs:
+		t.Fatalf("got %+v, want nothing", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// command sends msg and returns the ack or error answering it. Messages sent
+// meanwhile are kept for next.
+func (c *realtimeClient) command(t *testing.T, msg wsMessage) wsMessage {
+	t.Helper()
+	c.send(t, msg)
+	for {
+		select {
+		case reply, ok := <-c.msgs:
+			if !ok {
+				t.Fatal("connection closed")
+			}
+			if reply.Id == msg.Id {
+				return reply
+			}
+			c.early = append(c.early, reply)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not answered", msg.Type)
+		}
+	}
+}
+
+func (c *realtimeClient) subscribe(t *testing.T, topic, userId, lastEventId string) {
+	t.Helper()
+	msg := wsMessage{Type: wsMessageSubscribe, Id: "sub", Topic: topic, UserId: userId, LastEventId: lastEventId}
+	if reply := c.command(t, msg); reply.Type != wsMessageAck {
+		t.Fatalf("subscribe = %+v, want an ack", reply)
+	}
+}
+
+// expectActivity checks the next message is the activity.
+func (c *realtimeClient) expectActivity(t *testing.T, feedId string, seq uint64) wsMessage {
+	t.Helper()
+	msg := c.next(t)
+	if msg.Type != wsMessageActivity || msg.Activity["feedId"] != feedId || msg.Seq != seq {
+		t.Fatalf("got %+v, want activity %s with seq %d", msg, feedId, seq)
+	}
+	return msg
+}
+
+// expectPresence checks the next message is the user's presence.
+func (c *realtimeClient) expectPresence(t *testing.T, userId string, want proto.Presence) {
+	t.Helper()
+	msg := c.next(t)
+	if msg.Type != wsMessagePresence || msg.UserId != userId || msg.Presence != want.String() {
+		t.Fatalf("got %+v, want %s presence %s", msg, userId, want)
+	}
+}
+
+// publishWave broadcasts an activity of the user waving.
+func publishWave(ts *testServer, feedId, userId string) {
+	ts.broadcaster.Publish(&proto.UserActivity{
+		FeedId:             feedId,
+		ActionTextTemplate: "{subject} waved.",
+		SubjectReferring:   []*proto.UserActivityReferring{{Type: proto.ReferringType_USER, Id: userId}},
+	})
+}
+
+func TestRealtimeSubscribeAndUnsubscribe(t *testing.T) {
+	ts := newTestServer(t)
+	c := connectRealtime(t, ts, "")
+
+	for _, test := range []struct {
+		msg  wsMessage
+		want string
+	}{
+		{wsMessage{Type: wsMessageSubscribe, Id: "1", Topic: "likes", UserId: "2"}, `unknown topic "likes"`},
+		{wsMessage{Type: wsMessageSubscribe, Id: "2", Topic: wsTopicActivities}, "userId is required"},
+		{wsMessage{Type: wsMessageSubscribe, Id: "3", Topic: wsTopicActivities, UserId: "404"}, "user not found"},
+		{wsMessage{Type: wsMessageUnsubscribe, Id: "4", Topic: wsTopicActivities, UserId: "2"}, `not subscribed to activities of "2"`},
+		{wsMessage{Type: "shout", Id: "5"}, `unknown message type "shout"`},
+	} {
+		if reply := c.command(t, test.msg); reply.Type != wsMessageError || reply.Message != test.want {
+			t.Errorf("%s = %+v, want error %q", test.msg.Type, reply, test.want)
+		}
+	}
+
+	c.subscribe(t, wsTopicActivities, "2", "")
+	// Subscribing twice changes nothing.
+	c.subscribe(t, wsTopicActivities, "2", "")
+	publishWave(ts, "wave3", "3")
+	publishWave(ts, "wave2", "2")
+	msg := c.expectActivity(t, "wave2", 1)
+	if msg.Topic != wsTopicActivities || msg.UserId != "2" || msg.EventId == "" {
+		t.Fatalf("activity = %+v", msg)
+	}
+	c.expectNone(t)
+
+	if reply := c.command(t, wsMessage{Type: wsMessageUnsubscribe, Id: "6", Topic: wsTopicActivities, UserId: "2"}); reply.Type != wsMessageAck {
+		t.Fatalf("unsubscribe = %+v, want an ack", reply)
+	}
+	publishWave(ts, "wave2b", "2")
+	c.expectNone(t)
+}
+
+func TestRealtimeAckWindow(t *testing.T) {
+	ts := newTestServer(t)
+	// Enough history that the pump, held up by the window, can resume.
+	ts.broadcaster = newActivityBroadcaster(1000, 16)
+	c := connectRealtime(t, ts, "")
+	c.subscribe(t, wsTopicActivities, "2", "")
+
+	for i := range wsMaxUnacked + 5 {
+		publishWave(ts, fmt.Sprint("wave", i), "2")
+	}
+	for i := range wsMaxUnacked {
+		c.expectActivity(t, fmt.Sprint("wave", i), uint64(i+1))
+	}
+	c.expectNone(t)
+
+	if reply := c.command(t, wsMessage{Type: wsMessageAck, Id: "1", Seq: wsMaxUnacked + 1}); reply.Type != wsMessageError {
+		t.Fatalf("ack of an unsent seq = %+v, want an error", reply)
+	}
+	// Acking part of the window lets as many more through.
+	c.send(t, wsMessage{Type: wsMessageAck, Seq: 3})
+	for i := wsMaxUnacked; i < wsMaxUnacked+3; i++ {
+		c.expectActivity(t, fmt.Sprint("wave", i), uint64(i+1))
+	}
+	c.expectNone(t)
+	c.send(t, wsMessage{Type: wsMessageAck, Seq: wsMaxUnacked + 3})
+	for i := wsMaxUnacked + 3; i < wsMaxUnacked+5; i++ {
+		c.expectActivity(t, fmt.Sprint("wave", i), uint64(i+1))
+	}
+}
+
+func TestRealtimeResume(t *testing.T) {
+	ts := newTestServer(t)
+	c := connectRealtime(t, ts, "")
+	c.subscribe(t, wsTopicActivities, "2", "")
+	publishWave(ts, "wave1", "2")
+	publishWave(ts, "wave2", "2")
+	c.expectActivity(t, "wave1", 1)
+	lastEventId := c.expectActivity(t, "wave2", 2).EventId
+	c.conn.Close()
+
+	publishWave(ts, "wave3", "3")
+	publishWave(ts, "wave4", "2")
+
+	c = connectRealtime(t, ts, "")
+	c.subscribe(t, wsTopicActivities, "2", lastEventId)
+	c.expectActivity(t, "wave4", 1)
+	c.expectNone(t)
+
+	for name, eventId := range map[string]string{
+		"unknown id":    "next-week",
+		"another epoch": "1-1",
+		"aged out":      "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if eventId == "" {
+				// Push wave2 out of the history.
+				for i := range 100 {
+					publishWave(ts, fmt.Sprint("filler", i), "3")
+				}
+				eventId = lastEventId
+			}
+			c := connectRealtime(t, ts, "")
+			c.subscribe(t, wsTopicActivities, "2", eventId)
+			if msg := c.next(t); msg.Type != wsMessageReset || msg.Topic != wsTopicActivities || msg.UserId != "2" {
+				t.Fatalf("got %+v, want a reset", msg)
+			}
+		})
+	}
+}
+
+func TestRealtimePresence(t *testing.T) {
+	t.Run("heartbeats to this instance", func(t *testing.T) {
+		ts := newTestServer(t)
+		c := connectRealtime(t, ts, "")
+		c.subscribe(t, wsTopicPresence, "1", "")
+		c.expectPresence(t, "1", proto.Presence_OFFLINE)
+
+		alice := token(t, "1", "")
+		expectStatus(t, ts.do(t, http.MethodPost, "/users/1/heartbeat", alice, nil), http.StatusNoContent)
+		c.expectPresence(t, "1", proto.Presence_ONLINE)
+		// Staying online is no change.
+		expectStatus(t, ts.do(t, http.MethodPost, "/users/1/heartbeat", alice, nil), http.StatusNoContent)
+		c.expectNone(t)
+
+		if reply := c.command(t, wsMessage{Type: wsMessageUnsubscribe, Id: "1", Topic: wsTopicPresence, UserId: "1"}); reply.Type != wsMessageAck {
+			t.Fatalf("unsubscribe = %+v, want an ack", reply)
+		}
+	})
+
+	t.Run("heartbeats to another instance", func(t *testing.T) {
+		ts := newTestServer(t)
+		ts.presence.flushInterval = 10 * time.Millisecond
+		// last_seen is kept to the second.
+		ts.presence.onlineWithin = 2 * time.Second
+		c := connectRealtime(t, ts, "")
+		c.subscribe(t, wsTopicPresence, "1", "")
+		c.expectPresence(t, "1", proto.Presence_OFFLINE)
+
+		// What the other instance's flush leaves in the store.
+		if err := ts.store.TouchUsers(context.Background(), map[string]time.Time{"1": time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		c.expectPresence(t, "1", proto.Presence_ONLINE)
+		// Going away needs no heartbeat to notice.
+		c.expectPresence(t, "1", proto.Presence_AWAY)
+	})
+}
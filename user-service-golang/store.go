@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the write clashes with existing data, such as a
+	// name that is already taken.
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid means the store rejects the data as it is, so writing it
+	// again won't help.
+	ErrInvalid = errors.New("invalid")
+)
+
+// UserStore is the read/write access to the users table.
+type UserStore interface {
+	ListUsers(ctx context.Context) ([]*proto.User, error)
+	// ListUsersPage returns a page of users in id order, and whether there
+	// are more past it.
+	ListUsersPage(ctx context.Context, q pageQuery) (users []*proto.User, more bool, err error)
+	GetUser(ctx context.Context, id string) (*proto.User, error)
+	// GetUsers returns the users with the given ids in id order, leaving
+	// out those that don't exist.
+	GetUsers(ctx context.Context, ids []string) ([]*proto.User, error)
+	// CreateUser inserts a user whose Id the caller has generated.
+	CreateUser(ctx context.Context, user *proto.User) (*proto.User, error)
+	// UpdateUser sets the name and activity visibility of a user; last_seen
+	// is left alone.
+	UpdateUser(ctx context.Context, user *proto.User) (*proto.User, error)
+	// DeleteUser removes a user along with their referrings, and any
+	// activity that is left without subjects or objects as a result.
+	DeleteUser(ctx context.Context, id string) error
+	// ListUsersSeenSince returns the users whose last_seen is at or after
+	// since, in id order.
+	ListUsersSeenSince(ctx context.Context, since time.Time) ([]*proto.User, error)
+	// TouchUsers moves last_seen forward to the given times in one batch.
+	// Unknown ids are ignored, and last_seen never moves backwards.
+	TouchUsers(ctx context.Context, seen map[string]time.Time) error
+}
+
+// ActivityStore is the read/write access to user activities together with
+// their subject and object referrings.
+type ActivityStore interface {
+	ListActivities(ctx context.Context) ([]*proto.UserActivity, error)
+	// ListActivitiesPage returns a page of the activities matching f, newest
+	// first, and whether there are more past it.
+	ListActivitiesPage(ctx context.Context, f activityFilter, q pageQuery) (activities []*proto.UserActivity, more bool, err error)
+	GetActivity(ctx context.Context, feedId string) (*proto.UserActivity, error)
+	// UpsertActivity replaces the activity and all of its referrings atomically.
+	UpsertActivity(ctx context.Context, activity *proto.UserActivity) error
+}
+
+const lastSeenLayout = "2006-01-02T15:04:05Z"
+
+// createdAtLayout keeps the microseconds Postgres stores, which activity
+// cursors depend on.
+const createdAtLayout = "2006-01-02T15:04:05.999999Z"
+
+func parseReferringType(referringType string) proto.ReferringType {
+	switch strings.ToUpper(referringType) {
+	case "USER":
+		return proto.ReferringType_USER
+	case "POST":
+		return proto.ReferringType_POST
+	default:
+		return proto.ReferringType_USER
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"charles/career-break-learn/user-service-golang/proto"
+)
+
+const (
+	benchUsers      = 200
+	benchActivities = 20000
+	benchPageSize   = 20
+)
+
+// seedBenchActivities stores benchActivities activities spread over
+// benchUsers users, each with a user subject and a post owned by another.
+func seedBenchActivities(b *testing.B, activities ActivityStore) {
+	b.Helper()
+	ctx := context.Background()
+	if _, err := activities.GetActivity(ctx, "bench0"); err == nil {
+		return
+	} else if !errors.Is(err, ErrNotFound) {
+		b.Fatal(err)
+	}
+	for i := range benchActivities {
+		err := activities.UpsertActivity(ctx, &proto.UserActivity{
+			FeedId:             fmt.Sprint("bench", i),
+			ActionTextTemplate: "{subject} liked {object} post.",
+			SubjectReferring:   []*proto.UserActivityReferring{{Type: proto.ReferringType_USER, Id: fmt.Sprint(i % benchUsers)}},
+			ObjectReferring: []*proto.UserActivityReferring{
+				{Type: proto.ReferringType_POST, Id: fmt.Sprint("post", i), UserId: fmt.Sprint(i * 7 % benchUsers)},
+			},
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkUserActivities compares reading the first page of a user's
+// activities by listing every activity and filtering in Go, as
+// GET /users/:id/feed used to, with asking the store for the page.
+func benchmarkUserActivities(b *testing.B, activities ActivityStore) {
+	seedBenchActivities(b, activities)
+	ctx := context.Background()
+	userId := "42"
+
+	b.Run("scan", func(b *testing.B) {
+		for b.Loop() {
+			all, err := activities.ListActivities(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var feed []*proto.UserActivity
+			for _, activity := range all {
+				if involvesUser(activity, userId) {
+					feed = append(feed, activity)
+				}
+			}
+			if len(feed) == 0 {
+				b.Fatal("user has no activities")
+			}
+		}
+	})
+	b.Run("page", func(b *testing.B) {
+		f := activityFilter{Id: userId, ByUser: true}
+		for b.Loop() {
+			if _, _, err := activities.ListActivitiesPage(ctx, f, pageQuery{Limit: benchPageSize}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkUserActivitiesMemory(b *testing.B) {
+	benchmarkUserActivities(b, newMemoryStore())
+}
+
+// BenchmarkUserActivitiesPostgres runs against the database at
+// TEST_DATABASE_URL, which it migrates and seeds on first use, so it should
+// be a throwaway one.
+func BenchmarkUserActivitiesPostgres(b *testing.B) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		b.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	m, err := newMigrator(db)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := m.Up(context.Background(), 0); err != nil {
+		b.Fatal(err)
+	}
+	benchmarkUserActivities(b, newPostgresStore(db))
+}
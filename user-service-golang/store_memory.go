@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// memoryStore implements UserStore and ActivityStore without a database.
+// Everything handed in or out is cloned so callers can't mutate the store.
+type memoryStore struct {
+	mu         sync.RWMutex
+	users      map[string]*proto.User
+	activities map[string]*proto.UserActivity
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:      make(map[string]*proto.User),
+		activities: make(map[string]*proto.UserActivity),
+	}
+}
+
+// newSeededMemoryStore returns a memory store holding the same rows as
+// db/seed.sql.
+func newSeededMemoryStore() *memoryStore {
+	s := newMemoryStore()
+	s.putUser(&proto.User{Id: "1", Name: "Alice", LastSeen: "2024-06-01T10:00:00Z"})
+	s.putUser(&proto.User{Id: "2", Name: "Bob", LastSeen: "2024-06-01T11:00:00Z"})
+	s.putUser(&proto.User{Id: "3", Name: "Charlie", LastSeen: "2024-06-01T12:00:00Z"})
+	_ = s.UpsertActivity(context.Background(), &proto.UserActivity{
+		FeedId:             "feed1",
+		ActionTextTemplate: "{subject} commented on {object} post.",
+		SubjectReferring: []*proto.UserActivityReferring{
+			{Type: proto.ReferringType_USER, Id: "1", UserId: "1"},
+		},
+		ObjectReferring: []*proto.UserActivityReferring{
+			{Type: proto.ReferringType_POST, Id: "1024", UserId: "2"},
+		},
+	})
+	return s
+}
+
+func (s *memoryStore) putUser(user *proto.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.Id] = cloneUser(user)
+}
+
+func (s *memoryStore) ListUsers(ctx context.Context) ([]*proto.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*proto.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, cloneUser(user))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Id < users[j].Id })
+	return users, nil
+}
+
+func (s *memoryStore) ListUsersPage(ctx context.Context, q pageQuery) ([]*proto.User, bool, error) {
+	users, err := s.ListUsers(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	users, more := pageOf(users, userKey, compareUserKeys, q)
+	return users, more, nil
+}
+
+func (s *memoryStore) GetUser(ctx context.Context, id string) (*proto.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (s *memoryStore) GetUsers(ctx context.Context, ids []string) ([]*proto.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []*proto.User
+	for _, id := range ids {
+		if user, ok := s.users[id]; ok {
+			users = append(users, cloneUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Id < users[j].Id })
+	return slices.CompactFunc(users, func(a, b *proto.User) bool { return a.Id == b.Id }), nil
+}
+
+func (s *memoryStore) CreateUser(ctx context.Context, user *proto.User) (*proto.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.Id]; ok {
+		return nil, ErrConflict
+	}
+	if s.nameTaken(user.Name, user.Id) {
+		return nil, ErrConflict
+	}
+	s.users[user.Id] = cloneUser(user)
+	return cloneUser(user), nil
+}
+
+func (s *memoryStore) UpdateUser(ctx context.Context, user *proto.User) (*proto.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.Id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if s.nameTaken(user.Name, user.Id) {
+		return nil, ErrConflict
+	}
+	stored.Name = user.Name
+	stored.ActivityVisibility = user.ActivityVisibility
+	return cloneUser(stored), nil
+}
+
+func (s *memoryStore) ListUsersSeenSince(ctx context.Context, since time.Time) ([]*proto.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []*proto.User
+	for _, user := range s.users {
+		if lastSeen, _ := time.Parse(lastSeenLayout, user.LastSeen); !lastSeen.Before(since) {
+			users = append(users, cloneUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Id < users[j].Id })
+	return users, nil
+}
+
+func (s *memoryStore) TouchUsers(ctx context.Context, seen map[string]time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, at := range seen {
+		user, ok := s.users[id]
+		if !ok {
+			continue
+		}
+		if lastSeen, _ := time.Parse(lastSeenLayout, user.LastSeen); at.After(lastSeen) {
+			user.LastSeen = at.UTC().Format(lastSeenLayout)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) nameTaken(name, exceptId string) bool {
+	for id, user := range s.users {
+		if id != exceptId && strings.EqualFold(user.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteUser follows the Postgres store: the user's referrings go, then any
+// activity that lost all of its subjects or all of its objects.
+func (s *memoryStore) DeleteUser(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.users, id)
+
+	belongsToUser := func(referring *proto.UserActivityReferring) bool {
+		return referring.UserId == id ||
+			(referring.UserId == "" && referring.Type == proto.ReferringType_USER && referring.Id == id)
+	}
+	for feedId, activity := range s.activities {
+		subjects := slices.DeleteFunc(activity.SubjectReferring, belongsToUser)
+		lostSubjects := len(subjects) < len(activity.SubjectReferring)
+		objects := slices.DeleteFunc(activity.ObjectReferring, belongsToUser)
+		lostObjects := len(objects) < len(activity.ObjectReferring)
+		activity.SubjectReferring, activity.ObjectReferring = subjects, objects
+
+		if (lostSubjects && len(subjects) == 0) || (lostObjects && len(objects) == 0) {
+			delete(s.activities, feedId)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) ListActivities(ctx context.Context) ([]*proto.UserActivity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	activities := make([]*proto.UserActivity, 0, len(s.activities))
+	for _, activity := range s.activities {
+		activities = append(activities, cloneActivity(activity))
+	}
+	sort.Slice(activities, func(i, j int) bool { return activities[i].FeedId < activities[j].FeedId })
+	return activities, nil
+}
+
+func (s *memoryStore) ListActivitiesPage(ctx context.Context, f activityFilter, q pageQuery) ([]*proto.UserActivity, bool, error) {
+	s.mu.RLock()
+	var activities []*proto.UserActivity
+	for _, activity := range s.activities {
+		if f.matches(activity) && !s.hidden(f, activity) {
+			activities = append(activities, cloneActivity(activity))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(activities, func(i, j int) bool {
+		return compareActivityKeys(activityKey(activities[i]), activityKey(activities[j])) < 0
+	})
+	activities, more := pageOf(activities, activityKey, compareActivityKeys, q)
+	return activities, more, nil
+}
+
+func (s *memoryStore) GetActivity(ctx context.Context, feedId string) (*proto.UserActivity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	activity, ok := s.activities[feedId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneActivity(activity), nil
+}
+
+// hidden applies f.HidePrivate to activity. s.mu must be held.
+func (s *memoryStore) hidden(f activityFilter, activity *proto.UserActivity) bool {
+	if !f.HidePrivate {
+		return false
+	}
+	var owners []*proto.User
+	for _, id := range activityOwnerIds(activity) {
+		if owner, ok := s.users[id]; ok {
+			owners = append(owners, owner)
+		}
+	}
+	return hiddenByOwners(f, owners)
+}
+
+func (s *memoryStore) UpsertActivity(ctx context.Context, activity *proto.UserActivity) error {
+	stored := cloneActivity(activity)
+	// Mirror the (feed_id, referring_id) primary keys, whose duplicates the
+	// Postgres store drops after the first, and its
+	// ORDER BY feed_id, referring_id.
+	for _, referrings := range []*[]*proto.UserActivityReferring{&stored.SubjectReferring, &stored.ObjectReferring} {
+		seen := make(map[string]bool, len(*referrings))
+		*referrings = slices.DeleteFunc(*referrings, func(referring *proto.UserActivityReferring) bool {
+			duplicate := seen[referring.Id]
+			seen[referring.Id] = true
+			return duplicate
+		})
+		sort.SliceStable(*referrings, func(i, j int) bool { return (*referrings)[i].Id < (*referrings)[j].Id })
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// created_at is only set on insert.
+	if existing, ok := s.activities[stored.FeedId]; ok {
+		stored.CreatedAt = existing.CreatedAt
+	} else {
+		stored.CreatedAt = time.Now().UTC().Format(createdAtLayout)
+	}
+	s.activities[stored.FeedId] = stored
+	return nil
+}
+
+func cloneUser(user *proto.User) *proto.User {
+	return protobuf.Clone(user).(*proto.User)
+}
+
+func cloneActivity(activity *proto.UserActivity) *proto.UserActivity {
+	return protobuf.Clone(activity).(*proto.UserActivity)
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type postgresStore struct {
+	db *sql.DB
+	// queryDuration, if set, times every query by name.
+	queryDuration *prometheus.HistogramVec
+}
+
+func newPostgresStore(db *sql.DB) *postgresStore {
+	return &postgresStore{db: db}
+}
+
+// timeQuery starts timing a query for metrics; call the result when done.
+func (s *postgresStore) timeQuery(name string) func() {
+	if s.queryDuration == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		s.queryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Ping checks that the database can be reached.
+func (s *postgresStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *postgresStore) ListUsers(ctx context.Context) ([]*proto.User, error) {
+	defer s.timeQuery("list_users")()
+	return s.queryUsers(ctx, "SELECT id, name, last_seen, activity_visibility FROM users ORDER BY id")
+}
+
+func (s *postgresStore) ListUsersPage(ctx context.Context, q pageQuery) ([]*proto.User, bool, error) {
+	defer s.timeQuery("list_users_page")()
+
+	query := "SELECT id, name, last_seen, activity_visibility FROM users ORDER BY id LIMIT $1"
+	args := []any{q.Limit + 1}
+	switch {
+	case q.Before != nil:
+		query = "SELECT id, name, last_seen, activity_visibility FROM users WHERE id < $2 ORDER BY id DESC LIMIT $1"
+		args = append(args, q.Before.Id)
+	case q.After != nil:
+		query = "SELECT id, name, last_seen, activity_visibility FROM users WHERE id > $2 ORDER BY id LIMIT $1"
+		args = append(args, q.After.Id)
+	}
+	users, err := s.queryUsers(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	users, more := trimPage(users, q)
+	return users, more, nil
+}
+
+// trimPage cuts a page read with one entry to spare down to size, and puts
+// a page read backwards back in order.
+func trimPage[T any](entries []T, q pageQuery) ([]T, bool) {
+	more := len(entries) > q.Limit
+	if more {
+		entries = entries[:q.Limit]
+	}
+	if q.Before != nil {
+		slices.Reverse(entries)
+	}
+	return entries, more
+}
+
+func (s *postgresStore) queryUsers(ctx context.Context, query string, args ...any) ([]*proto.User, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*proto.User
+	for rows.Next() {
+		var user proto.User
+		var lastSeen sql.NullTime
+		var visibility string
+		if err := rows.Scan(&user.Id, &user.Name, &lastSeen, &visibility); err != nil {
+			return nil, err
+		}
+		if lastSeen.Valid {
+			user.LastSeen = lastSeen.Time.Format(lastSeenLayout)
+		}
+		user.ActivityVisibility = proto.ActivityVisibility(proto.ActivityVisibility_value[visibility])
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+func (s *postgresStore) GetUser(ctx context.Context, id string) (*proto.User, error) {
+	defer s.timeQuery("get_user")()
+
+	var user proto.User
+	var lastSeen sql.NullTime
+	var visibility string
+	err := s.db.QueryRowContext(ctx, "SELECT id, name, last_seen, activity_visibility FROM users WHERE id = $1", id).
+		Scan(&user.Id, &user.Name, &lastSeen, &visibility)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lastSeen.Valid {
+		user.LastSeen = lastSeen.Time.Format(lastSeenLayout)
+	}
+	user.ActivityVisibility = proto.ActivityVisibility(proto.ActivityVisibility_value[visibility])
+	return &user, nil
+}
+
+func (s *postgresStore) GetUsers(ctx context.Context, ids []string) ([]*proto.User, error) {
+	defer s.timeQuery("get_users")()
+	return s.queryUsers(ctx, "SELECT id, name, last_seen, activity_visibility FROM users WHERE id = ANY($1) ORDER BY id", pq.Array(ids))
+}
+
+func (s *postgresStore) ListActivities(ctx context.Context) ([]*proto.UserActivity, error) {
+	return s.loadActivities(ctx, "", nil)
+}
+
+func (s *postgresStore) GetActivity(ctx context.Context, feedId string) (*proto.UserActivity, error) {
+	activities, err := s.loadActivities(ctx, "WHERE feed_id = $1", []any{feedId})
+	if err != nil {
+		return nil, err
+	}
+	if len(activities) == 0 {
+		return nil, ErrNotFound
+	}
+	return activities[0], nil
+}
+
+// ListActivitiesPage finds the page in one query, going through the
+// referring_id and user_id indexes for f, then loads the referrings of just that page,
+// so it takes three round trips however many activities there are.
+func (s *postgresStore) ListActivitiesPage(ctx context.Context, f activityFilter, q pageQuery) ([]*proto.UserActivity, bool, error) {
+	defer s.timeQuery("list_activities_page")()
+
+	query, args := activityPageQuery(f, q)
+	activities, err := s.queryActivities(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	activities, more := trimPage(activities, q)
+
+	feedIds := make([]string, len(activities))
+	for i, activity := range activities {
+		feedIds[i] = activity.FeedId
+	}
+	if err := s.attachReferrings(ctx, activities, "WHERE feed_id = ANY($1)", []any{pq.Array(feedIds)}); err != nil {
+		return nil, false, err
+	}
+	return activities, more, nil
+}
+
+// activityPageQuery builds the query for the page q of the activities
+// matching f. It fetches one row more than the page holds, which trimPage
+// cuts off, to tell whether there are more.
+func activityPageQuery(f activityFilter, q pageQuery) (string, []any) {
+	args := []any{q.Limit + 1}
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	var where []string
+	if f.byReferring() {
+		var conditions []string
+		if f.Id != "" {
+			id := arg(f.Id)
+			if f.ByUser {
+				// Owned by the user, as deleteUserReferrings has it.
+				conditions = append(conditions, "(user_id = "+id+" OR (user_id IS NULL AND referring_type = 'USER' AND referring_id = "+id+"))")
+			} else {
+				conditions = append(conditions, "referring_id = "+id)
+			}
+		}
+		if f.ReferringType != nil {
+			conditions = append(conditions, "referring_type = "+arg(f.ReferringType.String()))
+		}
+		var tables []string
+		if f.Role != roleObject {
+			tables = append(tables, "user_activity_subject_referring")
+		}
+		if f.Role != roleSubject {
+			tables = append(tables, "user_activity_object_referring")
+		}
+		selects := make([]string, len(tables))
+		for i, table := range tables {
+			selects[i] = "SELECT feed_id FROM " + table + " WHERE " + strings.Join(conditions, " AND ")
+		}
+		where = append(where, "feed_id IN ("+strings.Join(selects, " UNION ")+")")
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created_at >= "+arg(f.Since))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created_at < "+arg(f.Until))
+	}
+	if f.Template != "" {
+		where = append(where, "action_text_template = "+arg(f.Template))
+	}
+	if f.HidePrivate {
+		// Referrings belong to their user_id, or are users themselves, as
+		// deleteUserReferrings has it.
+		reader := arg(f.Reader)
+		selects := make([]string, 2)
+		for i, table := range []string{"user_activity_subject_referring", "user_activity_object_referring"} {
+			selects[i] = "SELECT r.feed_id FROM " + table + " r JOIN users u ON u.id = COALESCE(r.user_id, CASE WHEN r.referring_type = 'USER' THEN r.referring_id END)" +
+				" WHERE u.activity_visibility = 'PRIVATE' AND u.id <> " + reader
+		}
+		where = append(where, "feed_id NOT IN ("+strings.Join(selects, " UNION ")+")")
+	}
+	order := "created_at DESC, feed_id DESC"
+	switch {
+	case q.Before != nil:
+		where = append(where, "(created_at, feed_id) > ("+arg(q.Before.CreatedAt)+", "+arg(q.Before.Id)+")")
+		order = "created_at, feed_id"
+	case q.After != nil:
+		where = append(where, "(created_at, feed_id) < ("+arg(q.After.CreatedAt)+", "+arg(q.After.Id)+")")
+	}
+
+	query := "SELECT feed_id, action_text_template, created_at FROM user_activities"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	return query + " ORDER BY " + order + " LIMIT $1", args
+}
+
+// loadActivities loads the activities matching where, then attaches their
+// subject and object referrings. where applies to all three tables, so it may
+// only reference feed_id.
+func (s *postgresStore) loadActivities(ctx context.Context, where string, args []any) ([]*proto.UserActivity, error) {
+	defer s.timeQuery("load_activities")()
+
+	activities, err := s.queryActivities(ctx, "SELECT feed_id, action_text_template, created_at FROM user_activities "+where+" ORDER BY feed_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.attachReferrings(ctx, activities, where, args); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// queryActivities runs a query for feed_id, action_text_template and
+// created_at, leaving out the referrings.
+func (s *postgresStore) queryActivities(ctx context.Context, query string, args ...any) ([]*proto.UserActivity, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activities []*proto.UserActivity
+	for rows.Next() {
+		var activity proto.UserActivity
+		var createdAt sql.NullTime
+		if err := rows.Scan(&activity.FeedId, &activity.ActionTextTemplate, &createdAt); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid {
+			activity.CreatedAt = createdAt.Time.UTC().Format(createdAtLayout)
+		}
+		activities = append(activities, &activity)
+	}
+	return activities, rows.Err()
+}
+
+// attachReferrings loads the subject and object referrings of activities
+// from the rows matching where, which may only reference feed_id.
+func (s *postgresStore) attachReferrings(ctx context.Context, activities []*proto.UserActivity, where string, args []any) error {
+	if len(activities) == 0 {
+		return nil
+	}
+	activityMap := make(map[string]*proto.UserActivity, len(activities))
+	for _, activity := range activities {
+		activityMap[activity.FeedId] = activity
+	}
+
+	// Load subject referring
+	err := s.loadReferrings(ctx, "user_activity_subject_referring", where, args, func(feedId string, referring *proto.UserActivityReferring) {
+		if activity := activityMap[feedId]; activity != nil {
+			activity.SubjectReferring = append(activity.SubjectReferring, referring)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Load object referring
+	return s.loadReferrings(ctx, "user_activity_object_referring", where, args, func(feedId string, referring *proto.UserActivityReferring) {
+		if activity := activityMap[feedId]; activity != nil {
+			activity.ObjectReferring = append(activity.ObjectReferring, referring)
+		}
+	})
+}
+
+func (s *postgresStore) loadReferrings(ctx context.Context, table, where string, args []any, add func(feedId string, referring *proto.UserActivityReferring)) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT feed_id, referring_type, referring_id, user_id
+		FROM `+table+` `+where+`
+		ORDER BY feed_id, referring_id
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var feedId, referringType, referringId string
+		var userId sql.NullString
+		if err := rows.Scan(&feedId, &referringType, &referringId, &userId); err != nil {
+			return err
+		}
+
+		referring := &proto.UserActivityReferring{
+			Id:   referringId,
+			Type: parseReferringType(referringType),
+		}
+		if userId.Valid {
+			referring.UserId = userId.String
+		}
+		add(feedId, referring)
+	}
+	return rows.Err()
+}
+
+func (s *postgresStore) UpsertActivity(ctx context.Context, activity *proto.UserActivity) error {
+	defer s.timeQuery("upsert_activity")()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := upsertActivityTx(ctx, tx, activity); err != nil {
+		return rejectedToInvalid(err)
+	}
+	return tx.Commit()
+}
+
+func upsertActivityTx(ctx context.Context, tx *sql.Tx, activity *proto.UserActivity) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO user_activities (feed_id, action_text_template) VALUES ($1, $2) ON CONFLICT (feed_id) DO UPDATE SET action_text_template = $2",
+		activity.FeedId, activity.ActionTextTemplate)
+	if err != nil {
+		return err
+	}
+
+	// Clear existing referring
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_activity_subject_referring WHERE feed_id = $1", activity.FeedId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_activity_object_referring WHERE feed_id = $1", activity.FeedId)
+	if err != nil {
+		return err
+	}
+
+	// Insert subject referring. The Java reducer joins subject lists, so the
+	// same referring may come twice; as in JdbcUserActivityRepository, the
+	// first one wins.
+	for _, referring := range activity.SubjectReferring {
+		_, err = tx.ExecContext(ctx, "INSERT INTO user_activity_subject_referring (feed_id, referring_type, referring_id, user_id) VALUES ($1, $2, $3, $4) ON CONFLICT (feed_id, referring_id) DO NOTHING",
+			activity.FeedId, referring.Type.String(), referring.Id, nullString(referring.UserId))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Insert object referring
+	for _, referring := range activity.ObjectReferring {
+		_, err = tx.ExecContext(ctx, "INSERT INTO user_activity_object_referring (feed_id, referring_type, referring_id, user_id) VALUES ($1, $2, $3, $4) ON CONFLICT (feed_id, referring_id) DO NOTHING",
+			activity.FeedId, referring.Type.String(), referring.Id, nullString(referring.UserId))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (s *postgresStore) CreateUser(ctx context.Context, user *proto.User) (*proto.User, error) {
+	defer s.timeQuery("create_user")()
+
+	lastSeen, err := time.Parse(lastSeenLayout, user.LastSeen)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkNameFree(ctx, tx, user.Name, user.Id); err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO users (id, name, last_seen, activity_visibility) VALUES ($1, $2, $3, $4)",
+		user.Id, user.Name, lastSeen, user.ActivityVisibility.String())
+	if err != nil {
+		return nil, uniqueViolationToConflict(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, uniqueViolationToConflict(err)
+	}
+	return s.GetUser(ctx, user.Id)
+}
+
+func (s *postgresStore) UpdateUser(ctx context.Context, user *proto.User) (*proto.User, error) {
+	defer s.timeQuery("update_user")()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkNameFree(ctx, tx, user.Name, user.Id); err != nil {
+		return nil, err
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE users SET name = $2, activity_visibility = $3 WHERE id = $1",
+		user.Id, user.Name, user.ActivityVisibility.String())
+	if err != nil {
+		return nil, uniqueViolationToConflict(err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, uniqueViolationToConflict(err)
+	}
+	return s.GetUser(ctx, user.Id)
+}
+
+func (s *postgresStore) DeleteUser(ctx context.Context, id string) error {
+	defer s.timeQuery("delete_user")()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	// There's no foreign key from the referring tables to users, so the
+	// user's referrings go by hand: anything they own, and USER referrings to
+	// them that have no user_id filled in.
+	subjectFeeds, err := deleteUserReferrings(ctx, tx, "user_activity_subject_referring", id)
+	if err != nil {
+		return err
+	}
+	objectFeeds, err := deleteUserReferrings(ctx, tx, "user_activity_object_referring", id)
+	if err != nil {
+		return err
+	}
+
+	// Activities that lost all their subjects, or all their objects, no
+	// longer say anything.
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM user_activities a
+		WHERE (a.feed_id = ANY($1) AND NOT EXISTS (SELECT 1 FROM user_activity_subject_referring s WHERE s.feed_id = a.feed_id))
+		OR (a.feed_id = ANY($2) AND NOT EXISTS (SELECT 1 FROM user_activity_object_referring o WHERE o.feed_id = a.feed_id))
+	`, pq.Array(subjectFeeds), pq.Array(objectFeeds))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteUserReferrings(ctx context.Context, tx *sql.Tx, table, userId string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		DELETE FROM `+table+`
+		WHERE user_id = $1 OR (user_id IS NULL AND referring_type = 'USER' AND referring_id = $1)
+		RETURNING feed_id
+	`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feedIds []string
+	for rows.Next() {
+		var feedId string
+		if err := rows.Scan(&feedId); err != nil {
+			return nil, err
+		}
+		feedIds = append(feedIds, feedId)
+	}
+	return feedIds, rows.Err()
+}
+
+func (s *postgresStore) ListUsersSeenSince(ctx context.Context, since time.Time) ([]*proto.User, error) {
+	defer s.timeQuery("list_users_seen_since")()
+	return s.queryUsers(ctx, "SELECT id, name, last_seen, activity_visibility FROM users WHERE last_seen >= $1::timestamp ORDER BY id",
+		since.UTC().Format("2006-01-02 15:04:05.999999"))
+}
+
+func (s *postgresStore) TouchUsers(ctx context.Context, seen map[string]time.Time) error {
+	defer s.timeQuery("touch_users")()
+
+	ids := make([]string, 0, len(seen))
+	times := make([]string, 0, len(seen))
+	for id, at := range seen {
+		ids = append(ids, id)
+		times = append(times, at.UTC().Format("2006-01-02 15:04:05.999999"))
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE users u SET last_seen = GREATEST(u.last_seen, v.seen)
+		FROM unnest($1::text[], $2::timestamp[]) AS v(id, seen)
+		WHERE u.id = v.id
+	`, pq.Array(ids), pq.Array(times))
+	return err
+}
+
+// checkNameFree fails with ErrConflict when a user other than exceptId
+// already goes by name, ignoring case.
+func checkNameFree(ctx context.Context, tx *sql.Tx, name, exceptId string) error {
+	var taken bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE lower(name) = lower($1) AND id <> $2)", name, exceptId).
+		Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrConflict
+	}
+	return nil
+}
+
+// rejectedToInvalid marks data exceptions and integrity constraint
+// violations, which no retry gets past, with ErrInvalid.
+func rejectedToInvalid(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && (pqErr.Code.Class() == "22" || pqErr.Code.Class() == "23") {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return err
+}
+
+// uniqueViolationToConflict turns a unique_violation from a concurrent write
+// that slipped past checkNameFree into ErrConflict.
+func uniqueViolationToConflict(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrConflict
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// getUserActivityStream pushes activities involving a user as Server-Sent
+// Events as they are stored. It takes the filter parameters of
+// GET /users/:id/activities and streams what that would list, each
+// "activity" event carrying the same JSON rendered for ?viewer=. Clients
+// resume with Last-Event-ID (or ?lastEventId= where they can't set headers);
+// a "reset" event means events were missed and the list should be reloaded.
+func (s *server) getUserActivityStream(c *gin.Context) {
+	id := c.Param("id")
+	if !s.authorizeUserActivities(c, id) {
+		return
+	}
+	f, ok := activityFilterFromRequest(c)
+	if !ok {
+		return
+	}
+	f.Id = id
+	s.restrictToReadable(&f, callerFromContext(c), claimsFromContext(c))
+	v, ok := s.viewerFromQuery(c)
+	if !ok {
+		return
+	}
+
+	lastEventId := c.GetHeader("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.Query("lastEventId")
+	}
+	sub, replay, resumed := s.broadcaster.Subscribe(lastEventId, f.matches)
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !resumed {
+		c.Render(-1, sse.Event{Event: "reset", Data: "missed events, reload"})
+	}
+	for _, event := range replay {
+		s.renderActivityEvent(c, event, v)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(s.sseHeartbeat)
+	defer heartbeat.Stop()
+	ctx, done := s.streamContext(c.Request.Context())
+	defer done()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case event, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects with
+				// its last event id and catches up from the history.
+				return false
+			}
+			s.renderActivityEvent(c, event, v)
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			return err == nil
+		}
+	})
+}
+
+// renderActivityEvent writes an activity event, unless the caller may not
+// read the activity, which the broadcaster's filter can't tell.
+func (s *server) renderActivityEvent(c *gin.Context, event activityEvent, v *feedViewer) {
+	readable, err := s.readableActivity(c.Request.Context(), event.Activity, callerFromContext(c), claimsFromContext(c))
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Failed to check who may read an activity", "feed_id", event.Activity.FeedId, "error", err)
+	}
+	if !readable {
+		return
+	}
+	if err := v.load(c.Request.Context(), event.Activity); err != nil {
+		slog.WarnContext(c.Request.Context(), "Failed to load the users an activity names", "feed_id", event.Activity.FeedId, "error", err)
+	}
+	c.Render(-1, sse.Event{
+		Id:    s.broadcaster.EventId(event),
+		Event: "activity",
+		Data:  protoActivityToJSON(event.Activity, v),
+	})
+}
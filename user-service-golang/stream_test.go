@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamEvent is an event read from a stream; FeedId is set for activities.
+type streamEvent struct {
+	Event, Id, FeedId string
+}
+
+// openStream subscribes to path on an httptest server of ts and returns the
+// feed ids of the activity events as they arrive.
+func openStream(t *testing.T, ts *testServer, path string) <-chan string {
+	t.Helper()
+	events := openStreamEvents(t, ts, path, nil)
+	feedIds := make(chan string, 16)
+	go func() {
+		for event := range events {
+			if event.Event == "activity" {
+				feedIds <- event.FeedId
+			}
+		}
+	}()
+	return feedIds
+}
+
+// openStreamEvents subscribes to path with the given request headers and
+// returns the events as they arrive.
+func openStreamEvents(t *testing.T, ts *testServer, path string, header http.Header) <-chan streamEvent {
+	t.Helper()
+	httpServer := httptest.NewServer(ts.router)
+	t.Cleanup(httpServer.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpServer.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+
+	events := make(chan streamEvent, 16)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var event streamEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			if name, ok := strings.CutPrefix(line, "event:"); ok {
+				event.Event = name
+			}
+			if id, ok := strings.CutPrefix(line, "id:"); ok {
+				event.Id = id
+			}
+			if data, ok := strings.CutPrefix(line, "data:"); ok && event.Event == "activity" {
+				var activity struct{ FeedId string }
+				if json.Unmarshal([]byte(data), &activity) == nil {
+					event.FeedId = activity.FeedId
+				}
+			}
+			if line == "" && event.Event != "" {
+				events <- event
+				event = streamEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+// nextEvent waits for the next event of a stream.
+func nextEvent(t *testing.T, events <-chan streamEvent) streamEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event streamed")
+		return streamEvent{}
+	}
+}
+
+func nextFeedId(t *testing.T, feedIds <-chan string) string {
+	t.Helper()
+	select {
+	case feedId := <-feedIds:
+		return feedId
+	case <-time.After(5 * time.Second):
+		t.Fatal("no activity streamed")
+		return ""
+	}
+}
+
+func TestUserActivityStream(t *testing.T) {
+	ts := newTestServer(t)
+	service := token(t, "processor", scopeActivitiesWriteAny)
+	bob := openStream(t, ts, "/users/2/activities/stream")
+	post := openStream(t, ts, "/users/998/activities/stream")
+	bobAsObject := openStream(t, ts, "/users/2/activities/stream?as=object")
+
+	// Bob owns the post, so it is his activity as much as the list's.
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", service,
+		activityBody("like1", "{subject} liked {object} post.", "3", map[string]any{"type": "POST", "id": "998", "userId": "2"})),
+		http.StatusCreated)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", service,
+		activityBody("wave1", "{subject} waved.", "2")), http.StatusCreated)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", service,
+		activityBody("wave2", "{subject} waved.", "998")), http.StatusCreated)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", service,
+		activityBody("like2", "{subject} liked {object} post.", "1", map[string]any{"type": "POST", "id": "999", "userId": "2"})),
+		http.StatusCreated)
+
+	for _, want := range []string{"like1", "wave1"} {
+		if got := nextFeedId(t, bob); got != want {
+			t.Fatalf("user 2 got %s, want %s", got, want)
+		}
+	}
+	// Referring ids aren't users.
+	if got := nextFeedId(t, post); got != "wave2" {
+		t.Fatalf("user 998 got %s, want wave2", got)
+	}
+	// The stream filters as the list does.
+	for _, want := range []string{"like1", "like2"} {
+		if got := nextFeedId(t, bobAsObject); got != want {
+			t.Fatalf("user 2 as object got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestUserActivityStreamLeavesOutPrivateActivities(t *testing.T) {
+	ts := newTestServer(t)
+	alice, bob := token(t, "1", ""), token(t, "2", "")
+	expectStatus(t, ts.do(t, http.MethodPatch, "/users/1", alice, map[string]any{"activityVisibility": "PRIVATE"}), http.StatusOK)
+	anonymous := openStream(t, ts, "/users/2/activities/stream")
+	own := openStream(t, ts, "/users/2/activities/stream?access_token="+alice)
+
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", alice,
+		activityBody("like1", "{subject} liked {object} post.", "1", map[string]any{"type": "POST", "id": "77", "userId": "2"})),
+		http.StatusCreated)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", bob, activityBody("wave1", "{subject} waved.", "2")), http.StatusCreated)
+
+	if got := nextFeedId(t, anonymous); got != "wave1" {
+		t.Fatalf("anonymous got %s, want wave1", got)
+	}
+	if got := nextFeedId(t, own); got != "like1" {
+		t.Fatalf("Alice got %s, want like1", got)
+	}
+}
+
+func TestUserActivityStreamResume(t *testing.T) {
+	ts := newTestServer(t)
+	bob := token(t, "2", "")
+	events := openStreamEvents(t, ts, "/users/2/activities/stream", nil)
+	for _, feedId := range []string{"wave1", "wave2", "wave3"} {
+		expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", bob, activityBody(feedId, "{subject} waved.", "2")), http.StatusCreated)
+	}
+	first := nextEvent(t, events)
+	if first.Event != "activity" || first.FeedId != "wave1" || first.Id == "" {
+		t.Fatalf("first event = %+v", first)
+	}
+
+	for name, open := range map[string]func() <-chan streamEvent{
+		"Last-Event-ID": func() <-chan streamEvent {
+			return openStreamEvents(t, ts, "/users/2/activities/stream", http.Header{"Last-Event-Id": {first.Id}})
+		},
+		"lastEventId": func() <-chan streamEvent {
+			return openStreamEvents(t, ts, "/users/2/activities/stream?lastEventId="+url.QueryEscape(first.Id), nil)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resumed := open()
+			for _, want := range []string{"wave2", "wave3"} {
+				if event := nextEvent(t, resumed); event.Event != "activity" || event.FeedId != want {
+					t.Fatalf("got %+v, want %s", event, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUserActivityStreamReset(t *testing.T) {
+	ts := newTestServer(t)
+	ts.broadcaster = newActivityBroadcaster(2, 16)
+	bob := token(t, "2", "")
+	events := openStreamEvents(t, ts, "/users/2/activities/stream", nil)
+	expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", bob, activityBody("wave1", "{subject} waved.", "2")), http.StatusCreated)
+	first := nextEvent(t, events)
+	epoch, _, _ := strings.Cut(first.Id, "-")
+	// Push wave1 out of the history.
+	for _, feedId := range []string{"wave2", "wave3"} {
+		expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", bob, activityBody(feedId, "{subject} waved.", "2")), http.StatusCreated)
+	}
+
+	for name, lastEventId := range map[string]string{
+		"aged out":      first.Id,
+		"another epoch": "1-1",
+		"in the future": epoch + "-99",
+		"malformed":     "wave1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			events := openStreamEvents(t, ts, "/users/2/activities/stream", http.Header{"Last-Event-Id": {lastEventId}})
+			if event := nextEvent(t, events); event.Event != "reset" {
+				t.Fatalf("got %+v, want a reset", event)
+			}
+			// Nothing from the history is replayed after a reset.
+			expectStatus(t, ts.do(t, http.MethodPost, "/users/-/activities", bob,
+				activityBody("wave-"+name, "{subject} waved.", "2")), http.StatusCreated)
+			if event := nextEvent(t, events); event.Event != "activity" || event.FeedId != "wave-"+name {
+				t.Fatalf("got %+v, want wave-%s", event, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action text templates look like
+//
+//	{subject} {subject|has|have} commented on {object's} post {time}.
+//
+// Placeholders are {subject}, {object}, {count} and {time}; {subject's} and
+// {object's} give the possessive form. {name|singular|plural} picks a section
+// by the count behind name, which is one of subject, object and count, and
+// sections may contain placeholders of their own. A backslash escapes the next
+// character, so \{ \} \| and \\ are literal.
+
+// TemplateError reports where a template failed to parse.
+type TemplateError struct {
+	Template string
+	Pos      int
+	Msg      string
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("template %q: at offset %d: %s", e.Template, e.Pos, e.Msg)
+}
+
+// Phrase is a rendered subject or object: its plain and possessive text and
+// how many referrings it stands for.
+type Phrase struct {
+	Text       string
+	Possessive string
+	Count      int
+}
+
+// TemplateData holds the values placeholders are filled with.
+type TemplateData struct {
+	Subject Phrase
+	Object  Phrase
+	Count   int
+	Time    time.Time
+	// TimeFormat renders {time}; a zero Time renders empty.
+	TimeFormat func(time.Time) string
+}
+
+type templateField int
+
+const (
+	fieldSubject templateField = iota
+	fieldObject
+	fieldCount
+	fieldTime
+)
+
+var templateFields = map[string]templateField{
+	"subject": fieldSubject,
+	"object":  fieldObject,
+	"count":   fieldCount,
+	"time":    fieldTime,
+}
+
+type templateNode struct {
+	text        string
+	field       templateField
+	placeholder bool
+	possessive  bool
+	// singular and plural are set for {name|singular|plural} sections.
+	singular, plural []templateNode
+	section          bool
+}
+
+// Template is a parsed action text template.
+type Template struct {
+	source string
+	nodes  []templateNode
+}
+
+// templateCacheSize bounds the parsed templates kept. Templates come from
+// clients, so there is no telling how many different ones there will be.
+const templateCacheSize = 1024
+
+var templateCache = newTemplateLRU(templateCacheSize)
+
+// templateLRU keeps the most recently used parsed templates.
+type templateLRU struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // of *Template, most recently used first
+	bysrc map[string]*list.Element
+}
+
+func newTemplateLRU(size int) *templateLRU {
+	return &templateLRU{size: size, order: list.New(), bysrc: make(map[string]*list.Element)}
+}
+
+func (c *templateLRU) get(src string) (*Template, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.bysrc[src]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*Template), true
+}
+
+func (c *templateLRU) add(t *Template) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.bysrc[t.source]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.bysrc[t.source] = c.order.PushFront(t)
+	if c.order.Len() > c.size {
+		oldest := c.order.Remove(c.order.Back()).(*Template)
+		delete(c.bysrc, oldest.source)
+	}
+}
+
+// ParseTemplate parses src, reusing recent results for the same source.
+func ParseTemplate(src string) (*Template, error) {
+	if t, ok := templateCache.get(src); ok {
+		return t, nil
+	}
+	p := &templateParser{src: src}
+	nodes, err := p.parse(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(src) {
+		return nil, p.errorf("unexpected %q", src[p.pos])
+	}
+	t := &Template{source: src, nodes: nodes}
+	templateCache.add(t)
+	return t, nil
+}
+
+func (t *Template) String() string {
+	return t.source
+}
+
+// Execute renders the template.
+func (t *Template) Execute(data TemplateData) string {
+	var b strings.Builder
+	executeNodes(&b, t.nodes, &data)
+	return b.String()
+}
+
+func executeNodes(b *strings.Builder, nodes []templateNode, data *TemplateData) {
+	for i := range nodes {
+		n := &nodes[i]
+		switch {
+		case n.section:
+			if data.count(n.field) == 1 {
+				executeNodes(b, n.singular, data)
+			} else {
+				executeNodes(b, n.plural, data)
+			}
+		case n.placeholder:
+			b.WriteString(data.value(n.field, n.possessive))
+		default:
+			b.WriteString(n.text)
+		}
+	}
+}
+
+func (data *TemplateData) count(field templateField) int {
+	switch field {
+	case fieldSubject:
+		return data.Subject.Count
+	case fieldObject:
+		return data.Object.Count
+	default:
+		return data.Count
+	}
+}
+
+func (data *TemplateData) value(field templateField, possessive bool) string {
+	switch field {
+	case fieldSubject:
+		if possessive {
+			return data.Subject.Possessive
+		}
+		return data.Subject.Text
+	case fieldObject:
+		if possessive {
+			return data.Object.Possessive
+		}
+		return data.Object.Text
+	case fieldCount:
+		return strconv.Itoa(data.Count)
+	default:
+		if data.Time.IsZero() {
+			return ""
+		}
+		if data.TimeFormat != nil {
+			return data.TimeFormat(data.Time)
+		}
+		return data.Time.Format(time.RFC3339)
+	}
+}
+
+type templateParser struct {
+	src string
+	pos int
+}
+
+func (p *templateParser) errorf(format string, args ...any) error {
+	return &TemplateError{Template: p.src, Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parse reads nodes up to the end of input or, inside a section, up to the
+// unescaped | or } that ends it.
+func (p *templateParser) parse(inSection bool) ([]templateNode, error) {
+	var nodes []templateNode
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, templateNode{text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\\':
+			if p.pos+1 >= len(p.src) {
+				return nil, p.errorf("trailing backslash")
+			}
+			text.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		case c == '{':
+			flush()
+			node, err := p.parsePlaceholder()
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		case c == '}' && !inSection:
+			return nil, p.errorf("unmatched }")
+		case (c == '|' || c == '}') && inSection:
+			flush()
+			return nodes, nil
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+	if inSection {
+		return nil, p.errorf("unterminated section")
+	}
+	flush()
+	return nodes, nil
+}
+
+func (p *templateParser) parsePlaceholder() (templateNode, error) {
+	start := p.pos
+	p.pos++ // {
+	end := p.pos
+	for end < len(p.src) && p.src[end] != '}' && p.src[end] != '|' {
+		end++
+	}
+	if end >= len(p.src) {
+		p.pos = start
+		return templateNode{}, p.errorf("unterminated placeholder")
+	}
+
+	name := p.src[p.pos:end]
+	possessive := strings.HasSuffix(name, "'s")
+	name = strings.TrimSuffix(name, "'s")
+	field, ok := templateFields[name]
+	if !ok {
+		return templateNode{}, p.errorf("unknown placeholder %q", p.src[p.pos:end])
+	}
+	if possessive && field != fieldSubject && field != fieldObject {
+		return templateNode{}, p.errorf("{%s's} has no possessive form", name)
+	}
+	p.pos = end
+
+	if p.src[p.pos] == '}' {
+		p.pos++
+		return templateNode{field: field, placeholder: true, possessive: possessive}, nil
+	}
+	if possessive {
+		return templateNode{}, p.errorf("section on possessive {%s's}", name)
+	}
+	if field == fieldTime {
+		return templateNode{}, p.errorf("{%s} has no count to pick a section by", name)
+	}
+
+	p.pos++ // |
+	singular, err := p.parse(true)
+	if err != nil {
+		return templateNode{}, err
+	}
+	if p.src[p.pos] != '|' {
+		return templateNode{}, p.errorf("section needs a singular and a plural form")
+	}
+	p.pos++
+	plural, err := p.parse(true)
+	if err != nil {
+		return templateNode{}, err
+	}
+	if p.src[p.pos] != '}' {
+		return templateNode{}, p.errorf("section has more than two forms")
+	}
+	p.pos++
+	return templateNode{field: field, section: true, singular: singular, plural: plural}, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTemplateLRU(t *testing.T) {
+	c := newTemplateLRU(2)
+	for _, src := range []string{"a", "b"} {
+		c.add(&Template{source: src})
+	}
+	c.get("a")
+	c.add(&Template{source: "c"})
+
+	for src, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.get(src); ok != want {
+			t.Errorf("cached %q = %v, want %v", src, ok, want)
+		}
+	}
+}
+
+func TestTemplateCacheBounded(t *testing.T) {
+	for i := range templateCacheSize + 10 {
+		if _, err := ParseTemplate(fmt.Sprintf("{subject} waved %d times.", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	templateCache.mu.Lock()
+	defer templateCache.mu.Unlock()
+	if n := len(templateCache.bysrc); n > templateCacheSize {
+		t.Fatalf("cache holds %d templates", n)
+	}
+}
+
+func TestTemplateTime(t *testing.T) {
+	tmpl, err := ParseTemplate("{subject} waved {time}.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	if got := tmpl.Execute(TemplateData{Subject: Phrase{Text: "Bob"}, Time: at}); got != "Bob waved 2024-06-01T12:00:00Z." {
+		t.Fatalf("got %q", got)
+	}
+	if got := tmpl.Execute(TemplateData{Subject: Phrase{Text: "Bob"}}); got != "Bob waved ." {
+		t.Fatalf("got %q without a time", got)
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, test := range []struct {
+		src string
+		pos int
+		msg string
+	}{
+		{"{subject waved.", 0, "unterminated placeholder"},
+		{"{subject} waved}", 15, "unmatched }"},
+		{"{actor} waved.", 1, `unknown placeholder "actor"`},
+		{"{subject} waved {when}.", 17, `unknown placeholder "when"`},
+		{"{count's} waved.", 1, "{count's} has no possessive form"},
+		{"{time|once|twice}", 5, "{time} has no count to pick a section by"},
+		{"{subject's|has|have}", 10, "section on possessive {subject's}"},
+		{"{subject|has}", 12, "section needs a singular and a plural form"},
+		{"{subject|has|have|had}", 17, "section has more than two forms"},
+		{"{subject} {subject|has|have", 27, "unterminated section"},
+		{`{subject} waved.\`, 16, "trailing backslash"},
+	} {
+		t.Run(test.src, func(t *testing.T) {
+			_, err := ParseTemplate(test.src)
+			var terr *TemplateError
+			if !errors.As(err, &terr) {
+				t.Fatalf("err = %v, want a TemplateError", err)
+			}
+			if terr.Pos != test.pos || terr.Msg != test.msg {
+				t.Fatalf("error at %d: %s, want at %d: %s", terr.Pos, terr.Msg, test.pos, test.msg)
+			}
+		})
+	}
+}
+
+func TestTemplateExecute(t *testing.T) {
+	bob := Phrase{Text: "Bob", Possessive: "Bob's", Count: 1}
+	two := Phrase{Text: "Bob and Charlie", Possessive: "Bob and Charlie's", Count: 2}
+	alice := Phrase{Text: "Alice", Possessive: "Alice's", Count: 1}
+
+	for _, test := range []struct {
+		name string
+		src  string
+		data TemplateData
+		want string
+	}{
+		{"placeholders", "{subject} commented on {object}.", TemplateData{Subject: bob, Object: alice}, "Bob commented on Alice."},
+		{"object possessive", "{subject} liked {object's} post.", TemplateData{Subject: bob, Object: alice}, "Bob liked Alice's post."},
+		{"subject possessive", "{subject's} post was liked by {object}.", TemplateData{Subject: two, Object: alice}, "Bob and Charlie's post was liked by Alice."},
+		{"subject singular", "{subject} {subject|has|have} commented.", TemplateData{Subject: bob}, "Bob has commented."},
+		{"subject plural", "{subject} {subject|has|have} commented.", TemplateData{Subject: two}, "Bob and Charlie have commented."},
+		{"count singular", "{count} {count|person|people} liked {object's} post.", TemplateData{Object: alice, Count: 1}, "1 person liked Alice's post."},
+		{"count plural", "{count} {count|person|people} liked {object's} post.", TemplateData{Object: alice, Count: 3}, "3 people liked Alice's post."},
+		{"count zero", "{count|one like|{count} likes}", TemplateData{}, "0 likes"},
+		{"placeholders in sections", "{object|{object's} post is|{object's} posts are} new.", TemplateData{Object: two}, "Bob and Charlie's posts are new."},
+		{"empty section", "{subject} liked it{count||, {count} times}.", TemplateData{Subject: bob, Count: 1}, "Bob liked it."},
+		{"escapes", `\{subject\} \| \\ {subject}`, TemplateData{Subject: bob}, `{subject} | \ Bob`},
+		{"escapes in sections", `{count|a\|b|c\}}`, TemplateData{Count: 1}, "a|b"},
+		{"escaped letter", `\w{subject}`, TemplateData{Subject: bob}, "wBob"},
+		{"no placeholders", "Nothing happened.", TemplateData{}, "Nothing happened."},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := ParseTemplate(test.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tmpl.Execute(test.data); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"charles/career-break-learn/user-service-golang/proto"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxNameLength = 255
+
+// userInput is the writable part of a user. Fields are nil when a PATCH
+// leaves them out.
+type userInput struct {
+	Name               *string `json:"name"`
+	ActivityVisibility *string `json:"activityVisibility"`
+}
+
+// bindUserInput reads a legacy JSON or binary protobuf proto.User body. In
+// protobuf an empty name or a PUBLIC visibility can't be told apart from a
+// missing one, so they count as missing.
+func bindUserInput(c *gin.Context) (userInput, error) {
+	if isProtobufRequest(c) {
+		var user proto.User
+		if err := bindProtobuf(c, &user); err != nil {
+			return userInput{}, err
+		}
+		var input userInput
+		if user.Name != "" {
+			input.Name = &user.Name
+		}
+		if user.ActivityVisibility != proto.ActivityVisibility_PUBLIC {
+			visibility := user.ActivityVisibility.String()
+			input.ActivityVisibility = &visibility
+		}
+		return input, nil
+	}
+
+	var input userInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		return userInput{}, err
+	}
+	return input, nil
+}
+
+// normalizeName trims name and checks it fits users.name.
+func normalizeName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return "", errors.New("name is required")
+	case utf8.RuneCountInString(name) > maxNameLength:
+		return "", fmt.Errorf("name must be at most %d characters", maxNameLength)
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return "", errors.New("name must not contain control characters")
+	}
+	return name, nil
+}
+
+// parseActivityVisibility parses PUBLIC or PRIVATE, in any case.
+func parseActivityVisibility(s string) (proto.ActivityVisibility, error) {
+	visibility, ok := proto.ActivityVisibility_value[strings.ToUpper(s)]
+	if !ok {
+		return 0, fmt.Errorf("activityVisibility must be PUBLIC or PRIVATE, not %q", s)
+	}
+	return proto.ActivityVisibility(visibility), nil
+}
+
+// newUserId returns a random version 4 UUID.
+func newUserId() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func (s *server) postUser(c *gin.Context) {
+	input, err := bindUserInput(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	name, err := normalizeName(*input.Name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var visibility proto.ActivityVisibility
+	if input.ActivityVisibility != nil {
+		if visibility, err = parseActivityVisibility(*input.ActivityVisibility); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	user, err := s.users.CreateUser(c.Request.Context(), &proto.User{
+		Id:                 newUserId(),
+		Name:               name,
+		LastSeen:           time.Now().UTC().Format(lastSeenLayout),
+		ActivityVisibility: visibility,
+	})
+	if errors.Is(err, ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "name is already taken"})
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.Header("Location", "/users/"+user.Id)
+	s.respondUser(c, http.StatusCreated, user)
+}
+
+// putUser replaces the writable fields of a user, so name is required.
+func (s *server) putUser(c *gin.Context) {
+	s.updateUser(c, true)
+}
+
+// patchUser changes only the fields given.
+func (s *server) patchUser(c *gin.Context) {
+	s.updateUser(c, false)
+}
+
+func (s *server) updateUser(c *gin.Context, replace bool) {
+	id := c.Param("id")
+	input, err := bindUserInput(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var name string
+	switch {
+	case input.Name != nil:
+		if name, err = normalizeName(*input.Name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case replace:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	var visibility proto.ActivityVisibility
+	if input.ActivityVisibility != nil {
+		if visibility, err = parseActivityVisibility(*input.ActivityVisibility); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	user, err := s.users.GetUser(ctx, id)
+	if err == nil && (input.Name != nil || input.ActivityVisibility != nil || replace) {
+		if input.Name != nil {
+			user.Name = name
+		}
+		// PUT replaces, so a visibility left out goes back to PUBLIC.
+		if input.ActivityVisibility != nil || replace {
+			user.ActivityVisibility = visibility
+		}
+		user, err = s.users.UpdateUser(ctx, user)
+	}
+
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		return
+	}
+	if errors.Is(err, ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "name is already taken"})
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	s.respondUser(c, http.StatusOK, user)
+}
+
+func (s *server) deleteUser(c *gin.Context) {
+	err := s.users.DeleteUser(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "user not found"})
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}